@@ -7,10 +7,11 @@ import (
 	qt "github.com/mappu/miqt/qt6"
 	"github.com/woshikedayaa/boxtray/common"
 	"github.com/woshikedayaa/boxtray/common/capi"
+	"github.com/woshikedayaa/boxtray/common/event"
+	"github.com/woshikedayaa/boxtray/common/gui"
 	"github.com/woshikedayaa/boxtray/config"
 	"github.com/woshikedayaa/boxtray/log"
 	"log/slog"
-	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -19,12 +20,80 @@ import (
 //go:embed resources/singbox.ico
 var icoByte []byte
 
-func Main(cfg config.Config) int {
+func Main(cfg config.Config, options Options) int {
 	logger := log.Get("init")
-	client, err := capi.NewClient(cfg.Api.Endpoint(), &capi.ClientConfig{
+	if options.Replay != "" {
+		api, err := startReplay(options.Replay)
+		if err != nil {
+			logger.Error("Start replay", slog.String("error", err.Error()))
+			return 1
+		}
+		logger.Info("Replay recording", slog.String("file", options.Replay))
+		cfg.Api = api
+	}
+	clientConfig := &capi.ClientConfig{
 		Timeout: 10 * time.Second,
 		Secret:  cfg.Api.Secret,
-	})
+		Retry:   capi.DefaultRetryPolicy(),
+
+		StreamTransport: cfg.Api.StreamTransport,
+	}
+	if r := cfg.Api.Retry; r.Attempts > 0 {
+		clientConfig.Retry.Attempts = r.Attempts
+	}
+	if r := cfg.Api.Retry; r.BaseDelay > 0 {
+		clientConfig.Retry.BaseDelay = time.Duration(r.BaseDelay) * time.Millisecond
+	}
+	if r := cfg.Api.Retry; r.MaxDelay > 0 {
+		clientConfig.Retry.MaxDelay = time.Duration(r.MaxDelay) * time.Millisecond
+	}
+	if r := cfg.Api.Retry; r.BreakerThreshold != 0 {
+		// negative disables the breaker
+		clientConfig.Retry.BreakerThreshold = max(0, r.BreakerThreshold)
+	}
+	if r := cfg.Api.Retry; r.BreakerCooldown > 0 {
+		clientConfig.Retry.BreakerCooldown = time.Duration(r.BreakerCooldown) * time.Millisecond
+	}
+	if t := cfg.Api.TLS; t != nil {
+		clientConfig.TLS = &capi.TLSConfig{
+			CAFile:     t.CAFile,
+			CertFile:   t.CertFile,
+			KeyFile:    t.KeyFile,
+			ServerName: t.ServerName,
+			PinSHA256:  t.PinSHA256,
+			Insecure:   t.Insecure,
+		}
+	}
+	if options.Record != "" {
+		recorder, file, err := openRecorder(options.Record)
+		if err != nil {
+			logger.Error("Open record file", slog.String("error", err.Error()))
+			return 1
+		}
+		defer file.Close()
+		logger.Info("Record session", slog.String("file", options.Record))
+		clientConfig.Recorder = recorder
+	}
+	switch cfg.Box.Health.Probe {
+	case "", config.ProbeVersion, config.ProbeProxies, config.ProbeURLTest:
+	default:
+		logger.Error("Unknown health probe", slog.String("probe", cfg.Box.Health.Probe))
+		return 1
+	}
+	if cfg.Box.Health.Probe == config.ProbeURLTest && cfg.Box.Health.Proxy == "" {
+		logger.Error("The url_test health probe needs health.proxy")
+		return 1
+	}
+	if cfg.Api.Control.StartOnLaunch && len(cfg.Api.Control.Start) == 0 {
+		logger.Warn("start_on_launch is set but the start command is not configured")
+	}
+	if cfg.Api.Control.StopOnQuit && len(cfg.Api.Control.Stop) == 0 {
+		logger.Warn("stop_on_quit is set but the stop command is not configured")
+	}
+	if cfg.Api.TLS != nil && cfg.Api.TLS.Insecure {
+		logger.Warn("tls certificate verification of the api is disabled")
+	}
+	client, err := capi.NewClient(cfg.Api.Endpoint(), clientConfig)
 	logger.Info("Set endpoint", slog.String("endpoint", cfg.Api.Endpoint()))
 	if err != nil {
 		logger.Error("Create Client", slog.String("error", err.Error()))
@@ -35,66 +104,75 @@ func Main(cfg config.Config) int {
 	return box.RunLoop(context.Background())
 }
 
-type BoxNotificationType uint16
-
-const (
-	NotificationTypeError BoxNotificationType = iota
-	NotificationTypeStatus
-)
-
-type BoxNotification struct {
-	Type    BoxNotificationType
-	Message any
-}
-
-func (no *BoxNotification) GetStatus() BoxStatus {
-	if no.Type == NotificationTypeStatus {
-		return no.Message.(BoxStatus)
-	}
-	panic("incorrect notification type")
-}
-
-func (no *BoxNotification) GetError() error {
-	if no.Type == NotificationTypeError {
-		return no.Message.(error)
-	}
-	panic("incorrect notification type")
-}
-
-type BoxStatus struct {
-	Up         bool
-	UpFromDown bool
-}
 type Box struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 	config config.Config
 
 	// Status
-	currentStatus    atomic.Bool
-	api              *capi.Client
-	subscribers      *sync.Map //map[string]chan BoxNotification
-	subscribersCount atomic.Int32
-	logger           *log.Logger
+	health      *coreHealth
+	probeWakeup chan struct{}
+	// publisherDone is closed once notificationPublisher returns
+	publisherDone chan struct{}
+	shutdownOnce  sync.Once
+	api           *capi.Client
+	logger        *log.Logger
+	// bus carries statuses, the transitions of health, to the tray sections
+	bus      *event.Bus
+	statuses *event.Topic[BoxStatus]
 
 	proxies *ProxiesManager
+	// capabilities of the core, detected from its version on every probe
+	capabilities atomic.Pointer[capi.Capabilities]
+	// hub shares the traffic, memory and connections streams between the sections
+	hub          *capi.Hub
+	streamStates *sync.Map // map[string]func(capi.StreamState)
 }
 
 func NewBox(client *capi.Client, cfg config.Config) *Box {
+	logger := log.Get("main")
 	if cfg.Box.UrlTest == "" {
 		cfg.Box.UrlTest = "https://google.com/generate_204"
 	}
 	if cfg.Box.MaxDelay < 1 {
 		cfg.Box.MaxDelay = 3000
 	}
+	if cfg.Box.Health.Probe == "" {
+		cfg.Box.Health.Probe = config.ProbeVersion
+	}
+	if cfg.Box.Health.Interval == 0 {
+		cfg.Box.Health.Interval = 3000
+	}
+	switch {
+	case cfg.Box.Health.DownInterval == 0:
+		cfg.Box.Health.DownInterval = max(cfg.Box.Health.Interval, 30000)
+	case cfg.Box.Health.DownInterval < cfg.Box.Health.Interval:
+		logger.Warn("health down_interval is shorter than interval, using interval",
+			slog.Uint64("down_interval", uint64(cfg.Box.Health.DownInterval)), slog.Uint64("interval", uint64(cfg.Box.Health.Interval)))
+		cfg.Box.Health.DownInterval = cfg.Box.Health.Interval
+	}
+	if cfg.Box.Health.Timeout == 0 {
+		cfg.Box.Health.Timeout = 3000
+	}
+	if cfg.Box.Health.FailureThreshold < 1 {
+		cfg.Box.Health.FailureThreshold = 3
+	}
+	if cfg.Box.ShutdownTimeout == 0 {
+		cfg.Box.ShutdownTimeout = 5000
+	}
 	b := &Box{
-		api:              client,
-		subscribers:      &sync.Map{},
-		subscribersCount: atomic.Int32{},
-		config:           cfg,
-		proxies:          NewProxiesManager(),
-		logger:           log.Get("main"),
+		api:           client,
+		config:        cfg,
+		proxies:       NewProxiesManager(cfg.Box.UrlTest),
+		logger:        logger,
+		streamStates:  &sync.Map{},
+		health:        newCoreHealth(cfg.Box.Health.FailureThreshold, cfg.Box.Health.SuccessThreshold),
+		probeWakeup:   make(chan struct{}, 1),
+		publisherDone: make(chan struct{}),
 	}
+	b.hub = client.NewHub(b.streamStateChanged)
+	b.bus = event.NewBus()
+	b.statuses = event.NewTopic[BoxStatus](b.bus, "status")
 	return b
 }
 
@@ -116,74 +194,78 @@ func (b *Box) initGui() {
 	tray.Show()
 }
 
-func (b *Box) clean() {
-	if b.cancel != nil {
-		b.cancel()
-	}
-	for b.subscribersCount.Load() != 0 {
-	}
-}
+// statusBuffer holds the statuses a busy section has not read yet, transitions are rare
+const statusBuffer = 32
+
+// drainInterval is how often the main thread runs the queued calls after the event loop ended
+const drainInterval = 10 * time.Millisecond
 
 func (b *Box) RunLoop(ctx context.Context) int {
 	if ctx == nil {
 		panic("nil context")
 	}
-	b.initGui()
 	b.ctx, b.cancel = context.WithCancel(ctx)
-	defer b.clean()
-	go b.notificationPublisher(b.ctx)
-	return qt.QApplication_Exec()
-}
-
-func (b *Box) broadCast(notification BoxNotification) {
-	b.subscribers.Range(func(key, value any) bool {
-		name := key.(string)
-		sub := value.(chan BoxNotification)
-		switch notification.Type {
-		// triangle , lmao
-		case NotificationTypeError:
-			go func() {
-				select {
-				case sub <- notification:
-				case <-time.After(1 * time.Second):
-					if notification.Message != nil {
-						if e, ok := notification.Message.(error); ok {
-							b.logger.Error("notification spend too much time!", slog.String("error", e.Error()), slog.String("type", "error"), slog.String("name", name))
-							return
-						}
-					}
-					panic(fmt.Sprintf("a error occurred while handling a error: not a standing error: %v", notification.Message))
-				}
-			}()
-		case NotificationTypeStatus:
-			go func() {
-				select {
-				case sub <- notification:
-				case <-time.After(1 * time.Second):
-					b.logger.Warn("notification spend too much time!", slog.String("name", name), slog.String("type", "status"), slog.String("Status", fmt.Sprintf("%s", strconv.FormatBool(notification.GetStatus().Up))))
-				}
-			}()
+	b.initGui()
+	b.transition(b.health.set(CoreConnecting, nil))
+	go func() {
+		defer close(b.publisherDone)
+		b.notificationPublisher(b.ctx)
+	}()
+	if b.config.Api.Control.StartOnLaunch {
+		go b.startOnLaunch()
+	}
+	code := qt.QApplication_Exec()
+	// the event loop may end without Quit, e.g. on logout,
+	// the sections still wait on the main thread, so run their calls here until shutdown is done
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.shutdown()
+	}()
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+	for {
+		qt.QCoreApplication_ProcessEvents()
+		select {
+		case <-done:
+			return code
+		case <-ticker.C:
 		}
-		return true
-	})
-	if notification.Type == NotificationTypeError {
-		b.currentStatus.Store(false)
 	}
 }
 
 func (b *Box) CloseManually() error {
+	return b.stopCore(b.ctx)
+}
+
+// stopCore runs the stop command under ctx
+func (b *Box) stopCore(ctx context.Context) error {
 	if len(b.config.Api.Control.Stop) == 0 {
 		return fmt.Errorf("stop command not configured")
 	}
 	b.logger.Debug("close now", slog.String("command", fmt.Sprint(b.config.Api.Control.Stop)))
-	return common.RunOneShot(b.ctx, b.config.Api.Control.Stop[0], b.config.Api.Control.Stop[1:])
+	status, ok := b.health.set(CoreStopping, nil)
+	b.transition(status, ok)
+	defer b.probeNow()
+	err := common.RunOneShot(ctx, b.config.Api.Control.Stop[0], b.config.Api.Control.Stop[1:])
+	if err != nil && ok {
+		// the core is where it was, the next probe tells for sure
+		b.transition(b.health.set(status.Previous, nil))
+	}
+	return err
 }
 func (b *Box) StartManually() error {
 	if len(b.config.Api.Control.Start) == 0 {
 		return fmt.Errorf("start command not configured")
 	}
 	b.logger.Debug("start now", slog.String("command", fmt.Sprint(b.config.Api.Control.Start)))
-	return common.RunOneShot(b.ctx, b.config.Api.Control.Start[0], b.config.Api.Control.Start[1:])
+	b.transition(b.health.set(CoreStarting, nil))
+	defer b.probeNow()
+	err := common.RunOneShot(b.ctx, b.config.Api.Control.Start[0], b.config.Api.Control.Start[1:])
+	if err != nil {
+		b.transition(b.health.set(CoreDown, err))
+	}
+	return err
 }
 
 func (b *Box) UpdateManually() error {
@@ -194,79 +276,96 @@ func (b *Box) UpdateManually() error {
 	return common.RunOneShot(b.ctx, b.config.Api.Control.Update[0], b.config.Api.Control.Update[1:])
 }
 
-func (b *Box) Subscribe(name string) <-chan BoxNotification {
-	ch := make(chan BoxNotification)
-	if _, exist := b.subscribers.Load(name); exist {
-		panic("duplicated subscriber")
+// Capabilities returns what the connected core supports, everything is allowed before the first probe
+func (b *Box) Capabilities() capi.Capabilities {
+	if c := b.capabilities.Load(); c != nil {
+		return *c
+	}
+	return capi.DetectCapabilities(capi.Version{})
+}
+
+func (b *Box) setCapabilities(version capi.Version) {
+	c := capi.DetectCapabilities(version)
+	if old := b.capabilities.Swap(&c); old == nil || old.Flavor != c.Flavor {
+		b.logger.Info("detect core", slog.String("flavor", c.Flavor.String()), slog.String("version", version.Version))
+	}
+}
+
+// OnStreamState binds f to the state changes of a hub topic
+func (b *Box) OnStreamState(topic string, f func(state capi.StreamState)) {
+	b.streamStates.Store(topic, f)
+}
+
+func (b *Box) streamStateChanged(topic string, state capi.StreamState, err error) {
+	if err != nil {
+		b.logger.Debug("stream state changed", slog.String("topic", topic), slog.String("state", state.String()), slog.String("error", err.Error()))
+	}
+	if f, ok := b.streamStates.Load(topic); ok {
+		f.(func(capi.StreamState))(state)
 	}
-	b.subscribers.Store(name, ch)
-	b.subscribersCount.Add(1)
+}
+
+// Subscribe returns the statuses for a tray section, the subscription must be closed once the section is done
+func (b *Box) Subscribe(name string) *event.Subscription[BoxStatus] {
 	b.logger.Debug("new subscribe", slog.String("name", name))
-	return ch
+	return b.statuses.Subscribe(b.ctx, event.Options{Buffer: statusBuffer, Policy: event.DropOldest})
 }
 
-func (b *Box) Unsubscribe(name string) {
-	if ch, exist := b.subscribers.Load(name); exist {
-		close(ch.(chan BoxNotification))
-		b.subscribers.Delete(name)
-		b.subscribersCount.Add(-1)
-		b.logger.Debug("unsubscribe", slog.String("name", name))
+// unsubscribe closes s, it reports the statuses s missed
+func (b *Box) unsubscribe(name string, s *event.Subscription[BoxStatus]) {
+	s.Close()
+	if dropped := s.Dropped(); dropped > 0 {
+		b.logger.Warn("subscriber missed statuses", slog.String("name", name), slog.Uint64("dropped", dropped))
 	}
+	b.logger.Debug("unsubscribe", slog.String("name", name))
 }
 
+// State returns the current state of the core
+func (b *Box) State() CoreState {
+	return b.health.Load()
+}
+
+// transition publishes status, ok comes from coreHealth and false drops it
+func (b *Box) transition(status BoxStatus, ok bool) {
+	if !ok {
+		return
+	}
+	logger := b.logger.With(slog.String("from", status.Previous.String()), slog.String("to", status.State.String()))
+	switch {
+	// a failed control action restores the previous state without a cause
+	case status.State == CoreUnauthorized && status.Cause != nil:
+		logger.Error("api secret rejected, check api.secret", slog.String("error", status.Cause.Error()))
+	case status.State == CoreDown && status.Cause != nil:
+		logger.Warn("detect service down", slog.String("cause", gui.ErrorText(status.Cause)), slog.String("error", status.Cause.Error()))
+	case status.State == CoreDegraded && status.Cause != nil:
+		logger.Warn("detect service degraded", slog.String("cause", gui.ErrorText(status.Cause)), slog.String("error", status.Cause.Error()))
+	case status.UpFromDown():
+		logger.Warn("detect service available now")
+	default:
+		logger.Info("core state changed")
+	}
+	b.statuses.Publish(status)
+}
+
+// notificationPublisher probes the core as box.health says and publishes the transitions it causes
 func (b *Box) notificationPublisher(ctx context.Context) {
-	ret := make(chan error)
-	next := make(chan struct{})
-	ticker := time.NewTicker(time.Second)
-	defer ticker.Stop()
-	go func() {
-		for range next {
-			_, err := b.api.GetVersion()
-			if err != nil {
-				ret <- err
-			}
+	wait := time.Duration(0)
+	for {
+		err := b.probe(ctx)
+		if ctx.Err() != nil {
+			return
 		}
-	}()
-	next <- struct{}{}
-	for range ticker.C {
+		b.transition(b.health.probed(err))
+		wait = b.nextProbe(wait)
+		timer := time.NewTimer(wait)
 		select {
-		case err := <-ret:
-			if err == nil {
-				continue
-			}
-			if b.currentStatus.Load() {
-				b.logger.Warn("detect service down", slog.String("error", err.Error()))
-				b.broadCast(BoxNotification{
-					Type:    NotificationTypeError,
-					Message: err,
-				})
-				b.broadCast(BoxNotification{
-					Type: NotificationTypeStatus,
-					Message: BoxStatus{
-						Up:         false,
-						UpFromDown: false,
-					},
-				})
-				b.currentStatus.Store(false)
-			}
-			next <- struct{}{}
 		case <-ctx.Done():
-			close(next)
+			timer.Stop()
 			return
-		default:
-			if !b.currentStatus.Load() {
-				b.logger.Warn("detect service available now")
-			}
-			// no error
-			b.broadCast(BoxNotification{
-				Type: NotificationTypeStatus,
-				Message: BoxStatus{
-					Up:         true,
-					UpFromDown: !b.currentStatus.Load(),
-				},
-			})
-			b.currentStatus.Store(true)
-			next <- struct{}{}
+		case <-b.probeWakeup:
+			timer.Stop()
+			wait = 0
+		case <-timer.C:
 		}
 	}
 }
@@ -0,0 +1,56 @@
+package boxtray
+
+import (
+	"context"
+	"errors"
+	"github.com/woshikedayaa/boxtray/common/capi"
+	"github.com/woshikedayaa/boxtray/common/capi/capitest"
+	"github.com/woshikedayaa/boxtray/config"
+	"github.com/woshikedayaa/boxtray/log"
+	"testing"
+	"time"
+)
+
+func newTestBox(t *testing.T, cfg config.Config) *Box {
+	t.Helper()
+	if err := log.Init(config.LogConfig{Level: "error"}); err != nil {
+		t.Fatal(err)
+	}
+	server := capitest.NewServer("")
+	t.Cleanup(server.Close)
+	client, err := capi.NewClient(server.URL, &capi.ClientConfig{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewBox(client, cfg)
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	t.Cleanup(b.cancel)
+	return b
+}
+
+// a stop command that fails puts the core back where it was, a state that usually comes with a cause
+func TestStopCoreFailsWhileDegraded(t *testing.T) {
+	cfg := config.Config{}
+	cfg.Api.Control.Stop = []string{"false"}
+	b := newTestBox(t, cfg)
+	b.transition(b.health.set(CoreDegraded, errors.New("timeout")))
+
+	statuses := b.Subscribe("test")
+	defer b.unsubscribe("test", statuses)
+	if err := b.CloseManually(); err == nil {
+		t.Fatal("the stop command must fail")
+	}
+	if state := b.State(); state != CoreDegraded {
+		t.Fatalf("state = %s, want degraded", state)
+	}
+	for _, want := range []CoreState{CoreStopping, CoreDegraded} {
+		select {
+		case status := <-statuses.C:
+			if status.State != want {
+				t.Fatalf("status = %s, want %s", status.State, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no %s status", want)
+		}
+	}
+}
@@ -7,10 +7,14 @@ import (
 	"github.com/mappu/miqt/qt6/mainthread"
 	"github.com/woshikedayaa/boxtray/common"
 	"github.com/woshikedayaa/boxtray/common/capi"
+	"github.com/woshikedayaa/boxtray/common/constant"
+	"github.com/woshikedayaa/boxtray/common/event"
 	"github.com/woshikedayaa/boxtray/common/gui"
 	"github.com/woshikedayaa/boxtray/log"
 	"log/slog"
-	"os"
+	"slices"
+	"strings"
+	"sync"
 )
 
 func (b *Box) initInfoGui(menu *qt.QMenu) {
@@ -33,54 +37,69 @@ func (b *Box) initInfoGui(menu *qt.QMenu) {
 	memoryAction.SetToolTip("Memory")
 
 	menu.AddActions([]*qt.QAction{versionAction, trafficAction, memoryAction})
-	ch := b.Subscribe(infoGuiSubscriberName)
+	statuses := b.Subscribe(infoGuiSubscriberName)
+
+	b.OnStreamState("traffic", func(state capi.StreamState) {
+		mainthread.Wait(func() {
+			trafficAction.SetToolTip(fmt.Sprintf("Traffic(%s)", state))
+		})
+	})
+	b.OnStreamState("memory", func(state capi.StreamState) {
+		mainthread.Wait(func() {
+			memoryAction.SetToolTip(fmt.Sprintf("Memory(%s)", state))
+		})
+	})
 
 	go func() {
-		defer b.Unsubscribe(infoGuiSubscriberName)
-		for no := range ch {
-			if no.Type != NotificationTypeStatus {
-				continue
-			}
-			status := no.GetStatus()
-			if status.Up && status.UpFromDown {
-				version, err := b.api.GetVersion()
+		defer b.unsubscribe(infoGuiSubscriberName, statuses)
+		// cancelStreams drops the traffic and memory subscriptions of the last up
+		cancelStreams := context.CancelFunc(func() {})
+		defer func() { cancelStreams() }()
+		up := false
+		for status := range statuses.C {
+			if b.setUp(status, up) {
+				version, err := b.api.GetVersion(b.ctx)
 				if err != nil {
 					logger.Error("get version failed", slog.String("error", err.Error()))
 					continue
 				}
+				up = true
 				mainthread.Wait(func() {
 					versionAction.SetText(version.Version)
 				})
+				cancelStreams()
 				ctx, cancel := context.WithCancel(b.ctx)
+				cancelStreams = cancel
+				traffic := b.hub.Traffic.Subscribe(ctx, event.Options{Buffer: 1})
+				memory := b.hub.Memory.Subscribe(ctx, event.Options{Buffer: 1})
 				go func() {
-					if err := b.api.GetTraffic(ctx, func(traffic capi.Traffic, stop context.CancelFunc) {
+					defer traffic.Close()
+					for tf := range traffic.C {
 						mainthread.Wait(func() {
-							trafficAction.SetText(fmt.Sprintf("↑ %s↓ %s", gui.TrafficText(traffic.Up), gui.TrafficText(traffic.Down)))
+							trafficAction.SetText(fmt.Sprintf("↑ %s↓ %s", gui.TrafficText(tf.Up), gui.TrafficText(tf.Down)))
 						})
-						if !b.currentStatus.Load() {
-							stop()
-						}
-					}); err != nil {
-						logger.Error("get traffic failed", slog.String("error", err.Error()))
 					}
-					cancel()
 				}()
 				go func() {
-					if err := b.api.GetMemory(ctx, func(memory capi.Memory, stop context.CancelFunc) {
+					defer memory.Close()
+					for mem := range memory.C {
 						mainthread.Wait(func() {
-							memoryAction.SetText(fmt.Sprintf("%s", gui.MemoryText(memory.Inuse)))
+							memoryAction.SetText(fmt.Sprintf("%s", gui.MemoryText(mem.Inuse)))
 						})
-						if !b.currentStatus.Load() {
-							stop()
-						}
-					}); err != nil {
-						logger.Error("get memory failed", slog.String("error", err.Error()))
 					}
-					cancel()
 				}()
-			} else if !status.Up {
+			} else if !b.State().Alive() {
+				up = false
+				cancelStreams()
 				mainthread.Wait(func() {
-					versionAction.SetText(defaultVersionText)
+					switch {
+					case status.Cause != nil:
+						versionAction.SetText(fmt.Sprintf("Version(%s)", gui.ErrorText(status.Cause)))
+					case status.State == CoreStarting || status.State == CoreStopping:
+						versionAction.SetText(fmt.Sprintf("Version(%s)", status.State))
+					default:
+						versionAction.SetText(defaultVersionText)
+					}
 					trafficAction.SetText(defaultTrafficText)
 					memoryAction.SetText(defaultMemoryText)
 				})
@@ -94,6 +113,8 @@ func (b *Box) initControlGui(menu *qt.QMenu) {
 	startAction.SetCheckable(true)
 	updateAction := qt.NewQAction2("Update")
 	updateAction.SetCheckable(true)
+	reloadAction := qt.NewQAction2("Reload config")
+	reloadAction.SetCheckable(false)
 
 	if len(b.config.Api.Control.Start) == 0 || len(b.config.Api.Control.Stop) == 0 {
 		b.logger.Warn("start or stop command not configured, disable start action")
@@ -108,12 +129,17 @@ func (b *Box) initControlGui(menu *qt.QMenu) {
 		if !startAction.IsEnabled() {
 			return
 		}
-		if b.currentStatus.Load() {
+		switch state := b.State(); state {
+		case CoreStarting, CoreStopping:
+			b.logger.Info("core is busy, try later", slog.String("state", state.String()))
+			// undo the toggle of the click
+			startAction.SetChecked(state == CoreStarting)
+		case CoreUp, CoreDegraded, CoreUnauthorized:
 			err := b.CloseManually()
 			if err != nil {
 				b.logger.Error("stop failed", slog.String("error", err.Error()))
 			}
-		} else {
+		default:
 			err := b.StartManually()
 			if err != nil {
 				b.logger.Error("start failed", slog.String("error", err.Error()))
@@ -130,23 +156,144 @@ func (b *Box) initControlGui(menu *qt.QMenu) {
 		}
 	})
 
+	reloadAction.OnTriggered(func() {
+		if !b.State().Alive() {
+			b.logger.Info("reload failed,the service has down.")
+			return
+		}
+		go func() {
+			err := b.api.ReloadConfig(b.ctx, b.config.Api.Control.ConfigPath, true)
+			if err != nil {
+				b.logger.Error("reload config failed", slog.String("error", err.Error()))
+				return
+			}
+			b.logger.Info("reload config finished")
+		}()
+	})
+
+	dnsAction := qt.NewQAction2("DNS query")
+	dnsAction.SetCheckable(false)
+	dnsAction.OnTriggered(func() {
+		if !b.State().Alive() {
+			b.logger.Info("dns query failed,the service has down.")
+			return
+		}
+		ok := false
+		input := qt.QInputDialog_GetText6(nil, "DNS query", "Domain [type]", qt.QLineEdit__Normal, "", &ok)
+		fields := strings.Fields(input)
+		if !ok || len(fields) == 0 {
+			return
+		}
+		name, qtype := fields[0], "A"
+		if len(fields) > 1 {
+			qtype = strings.ToUpper(fields[1])
+		}
+		go func() {
+			result, err := b.api.QueryDNS(b.ctx, name, qtype)
+			if err != nil {
+				b.logger.Error("dns query failed", slog.String("name", name), slog.String("type", qtype), slog.String("error", err.Error()))
+				mainthread.Wait(func() {
+					qt.QMessageBox_Warning(nil, "DNS query", err.Error())
+				})
+				return
+			}
+			mainthread.Wait(func() {
+				qt.QMessageBox_Information(nil, fmt.Sprintf("%s %s", name, qtype), gui.DNSText(result.Answer))
+			})
+		}()
+	})
+
+	flushFakeIPAction := qt.NewQAction2("Flush fake-ip cache")
+	flushFakeIPAction.SetCheckable(false)
+	flushFakeIPAction.OnTriggered(func() {
+		if !b.State().Alive() {
+			b.logger.Info("flush fake-ip cache failed,the service has down.")
+			return
+		}
+		go func() {
+			if err := b.api.FlushFakeIPCache(b.ctx); err != nil {
+				b.logger.Error("flush fake-ip cache failed", slog.String("error", err.Error()))
+				return
+			}
+			b.logger.Info("flush fake-ip cache finished")
+		}()
+	})
+
+	// restart and upgrade are mihomo only, they stay disabled until the core is known
+	restartAction := qt.NewQAction2("Restart core")
+	restartAction.SetCheckable(false)
+	restartAction.SetDisabled(true)
+	restartAction.OnTriggered(func() {
+		if !b.State().Alive() {
+			b.logger.Info("restart failed,the service has down.")
+			return
+		}
+		go func() {
+			if err := b.api.Restart(b.ctx); err != nil {
+				b.logger.Error("restart core failed", slog.String("error", err.Error()))
+				return
+			}
+			b.logger.Info("restart core finished")
+		}()
+	})
+
+	upgradeAction := qt.NewQAction2("Upgrade core")
+	upgradeAction.SetCheckable(false)
+	upgradeAction.SetDisabled(true)
+	upgradeAction.OnTriggered(func() {
+		if !b.State().Alive() {
+			b.logger.Info("upgrade failed,the service has down.")
+			return
+		}
+		go func() {
+			if err := b.api.Upgrade(b.ctx); err != nil {
+				b.logger.Error("upgrade core failed", slog.String("error", err.Error()))
+				return
+			}
+			b.logger.Info("upgrade core finished")
+		}()
+	})
+
 	menu.AddAction(startAction)
 	menu.AddAction(updateAction)
+	menu.AddAction(reloadAction)
+	menu.AddAction(dnsAction)
+	menu.AddAction(flushFakeIPAction)
+	menu.AddAction(restartAction)
+	menu.AddAction(upgradeAction)
 	const controlGuiSubscriberName = "control"
-	ch := b.Subscribe(controlGuiSubscriberName)
+	statuses := b.Subscribe(controlGuiSubscriberName)
 	go func() {
-		defer b.Unsubscribe(controlGuiSubscriberName)
-		for no := range ch {
-			if no.Type == NotificationTypeStatus && no.GetStatus().UpFromDown {
-				mainthread.Wait(
-					func() {
-						startAction.SetChecked(true)
-					})
-			} else if no.Type == NotificationTypeStatus && !no.GetStatus().Up {
+		defer b.unsubscribe(controlGuiSubscriberName, statuses)
+		up := false
+		for status := range statuses.C {
+			mainthread.Wait(
+				func() {
+					switch status.State {
+					case CoreStarting:
+						startAction.SetText("Starting")
+					case CoreStopping:
+						startAction.SetText("Stopping")
+					default:
+						startAction.SetText("Started")
+					}
+					startAction.SetToolTip(fmt.Sprintf("Core(%s)", status.State))
+					// an unauthorized core still runs, stopping it is what the toggle does
+					startAction.SetChecked(status.State.Alive() || status.State == CoreUnauthorized || status.State == CoreStarting)
+				})
+			if b.setUp(status, up) {
+				up = true
+				capabilities := b.Capabilities()
 				mainthread.Wait(
 					func() {
-						startAction.SetChecked(false)
+						reloadAction.SetEnabled(capabilities.Reload)
+						dnsAction.SetEnabled(capabilities.DNSQuery)
+						flushFakeIPAction.SetEnabled(capabilities.FakeIPFlush)
+						restartAction.SetEnabled(capabilities.Restart)
+						upgradeAction.SetEnabled(capabilities.Upgrade)
 					})
+			} else if !b.State().Alive() {
+				up = false
 			}
 		}
 	}()
@@ -156,8 +303,12 @@ func (b *Box) initBoxGui(menu *qt.QMenu) {
 	quitAction := qt.NewQAction2("Quit")
 	quitAction.OnTriggered(func() {
 		b.logger.Info("Quit triggered,exit now !")
-		b.cancel()
-		os.Exit(0)
+		quitAction.SetDisabled(true)
+		// shutdown needs the event loop for the last updates of the sections, quit it afterwards
+		go func() {
+			b.shutdown()
+			mainthread.Start(qt.QCoreApplication_Quit)
+		}()
 	})
 	menu.AddAction(quitAction)
 }
@@ -165,113 +316,258 @@ func (b *Box) initBoxGui(menu *qt.QMenu) {
 func (b *Box) initProxiesGui(menu *qt.QMenu) {
 	var (
 		proxiesMenus []*qt.QMenu
+		// live is false once the menus of the last build are torn down, only the main thread touches it
+		live = new(bool)
 	)
 	const proxiesNodeSubscribeName = "proxies-nodes"
-	ch := b.Subscribe(proxiesNodeSubscribeName)
-	go func() {
-		defer b.Unsubscribe(proxiesNodeSubscribeName)
-		for no := range ch {
-			if no.Type != NotificationTypeStatus {
-				continue
+	// placeholder tells why there are no proxies while the core is not up
+	placeholder := qt.NewQAction2("Proxies(offline Now)")
+	placeholder.SetDisabled(true)
+	menu.AddAction(placeholder)
+	// refresh asks for the menus to be built again, after a provider changed its nodes
+	refresh := make(chan struct{}, 1)
+	requestRefresh := func() {
+		select {
+		case refresh <- struct{}{}:
+		default:
+		}
+	}
+
+	build := func() {
+		proxies, err := b.api.GetProxies(b.ctx)
+		if err != nil {
+			b.logger.Error("get proxies failed", slog.String("error", err.Error()))
+			return
+		}
+		err = b.proxies.Parse(proxies)
+		if err != nil {
+			b.logger.Error("parse proxies failed", slog.String("error", err.Error()))
+			return
+		}
+		var providers []string
+		proxyProviders, err := b.api.GetProxyProviders(b.ctx)
+		if err != nil {
+			// keep going without them, not every core serves them
+			b.logger.Debug("get proxy providers failed", slog.String("error", err.Error()))
+		} else {
+			b.proxies.ParseProviders(proxyProviders)
+			providers = providerNames(proxyProviders)
+		}
+		mainthread.Wait(func() {
+			live = new(bool)
+			*live = true
+			if len(providers) > 0 {
+				subMenu := qt.NewQMenu4("Providers", menu.QWidget)
+				b.addProxyProviders(subMenu, providers, requestRefresh)
+				menu.AddMenu(subMenu)
+				proxiesMenus = append(proxiesMenus, subMenu)
 			}
-			status := no.GetStatus()
-			if status.Up && status.UpFromDown {
-				proxies, err := b.api.GetProxies()
-				if err != nil {
-					b.logger.Error("get proxies failed", slog.String("error", err.Error()))
-					return
-				}
-				err = b.proxies.Parse(proxies)
-				if err != nil {
-					b.logger.Error("parse proxies failed", slog.String("error", err.Error()))
+			selector := b.proxies.LoadSelector()
+			for pair := selector.Oldest(); pair != nil; pair = pair.Next() {
+				name, nodes := pair.Key, pair.Value
+				group := proxies.Proxies.Value(name)
+				subMenu := qt.NewQMenu4(name, menu.QWidget)
+				b.addProxiesSelector(subMenu, common.MapSlice[*capi.Proxy, string, []*capi.Proxy, []string](nodes, func(idx int, source *capi.Proxy) string {
+					return source.Name
+				}), group.Now, constant.NormalizeProxyType(group.Type) == constant.TypeSelector, live)
+				// sync
+				menu.AddMenu(subMenu)
+				proxiesMenus = append(proxiesMenus, subMenu)
+			}
+		})
+	}
+	teardown := func() {
+		if proxiesMenus == nil {
+			return
+		}
+		// a delay update in flight still checks live before it touches an action
+		b.proxies.UnbindDelays()
+		mainthread.Wait(func() {
+			*live = false
+			for _, v := range proxiesMenus {
+				menu.RemoveAction(v.MenuAction())
+				// the actions and the submenus are children of the menu
+				v.DeleteLater()
+			}
+		})
+		proxiesMenus = nil
+	}
+
+	statuses := b.Subscribe(proxiesNodeSubscribeName)
+	go func() {
+		defer b.unsubscribe(proxiesNodeSubscribeName, statuses)
+		for {
+			select {
+			case status, ok := <-statuses.C:
+				if !ok {
 					return
 				}
 				mainthread.Wait(func() {
-					selector := b.proxies.LoadSelector()
-					for pair := selector.Oldest(); pair != nil; pair = pair.Next() {
-						name, nodes := pair.Key, pair.Value
-						subMenu := qt.NewQMenu3(name)
-						b.addProxiesSelector(subMenu, common.MapSlice[*capi.Proxy, string, []*capi.Proxy, []string](nodes, func(idx int, source *capi.Proxy) string {
-							return source.Name
-						}), proxies.Proxies.Value(name).Now)
-						// sync
-						menu.AddMenu(subMenu)
-						proxiesMenus = append(proxiesMenus, subMenu)
+					placeholder.SetVisible(!status.Up())
+					if status.Cause != nil {
+						placeholder.SetText(fmt.Sprintf("Proxies(%s)", gui.ErrorText(status.Cause)))
+					} else {
+						placeholder.SetText(fmt.Sprintf("Proxies(%s)", status.State))
 					}
 				})
-			} else if !status.Up {
-				if proxiesMenus != nil {
-					mainthread.Wait(func() {
-						for _, v := range proxiesMenus {
-							menu.RemoveAction(v.MenuAction())
-						}
-					})
-					proxiesMenus = nil
+				if b.setUp(status, proxiesMenus != nil) {
+					teardown()
+					build()
+				} else if !b.State().Alive() && proxiesMenus != nil {
+					teardown()
 					b.logger.Info("service has down, remove all the proxies")
 				}
+			case <-refresh:
+				if b.State().Alive() {
+					teardown()
+					build()
+				}
 			}
 		}
 	}()
 }
-func (b *Box) addProxiesSelector(menu *qt.QMenu, nodes []string, now string) {
+
+// providerNames returns the sorted providers worth a menu.
+// The "Compatible" provider holds the outbounds from the core config itself, mihomo always reports it.
+func providerNames(providers *capi.ProxyProviders) []string {
+	names := make([]string, 0, len(providers.Providers))
+	for name, provider := range providers.Providers {
+		if strings.EqualFold(provider.VehicleType, constant.VehicleCompatible) {
+			continue
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// addProxyProviders adds the actions of every provider, refresh is called once one of them changed the nodes
+func (b *Box) addProxyProviders(menu *qt.QMenu, names []string, refresh func()) {
+	for _, name := range names {
+		subMenu := qt.NewQMenu4(name, menu.QWidget)
+		updateAction := qt.NewQAction5("Update", subMenu.QObject)
+		updateAction.OnTriggered(func() {
+			if !b.State().Alive() {
+				return
+			}
+			go func() {
+				if err := b.api.UpdateProxyProvider(b.ctx, name); err != nil {
+					b.logger.Error("update provider failed", slog.String("provider", name), slog.String("error", err.Error()))
+					return
+				}
+				b.logger.Info("update provider finished", slog.String("provider", name))
+				refresh()
+			}()
+		})
+		healthCheckAction := qt.NewQAction5("Health check", subMenu.QObject)
+		healthCheckAction.OnTriggered(func() {
+			if !b.State().Alive() {
+				return
+			}
+			go func() {
+				if err := b.api.HealthCheckProxyProvider(b.ctx, name); err != nil {
+					b.logger.Error("health check provider failed", slog.String("provider", name), slog.String("error", err.Error()))
+					return
+				}
+				b.logger.Info("health check provider finished", slog.String("provider", name))
+				refresh()
+			}()
+		})
+		subMenu.AddAction(updateAction)
+		subMenu.AddAction(healthCheckAction)
+		menu.AddMenu(subMenu)
+	}
+}
+
+// addProxiesSelector fills menu with the nodes of a group, only a selector can switch,
+// the other groups pick their node themselves and just show it.
+func (b *Box) addProxiesSelector(menu *qt.QMenu, nodes []string, now string, selectable bool, live *bool) {
 	var actions = make(map[string]*qt.QAction)
 	selector := menu.Title()
-	refreshButton := qt.NewQAction2("Refresh")
+	refreshButton := qt.NewQAction5("Refresh", menu.QObject)
 	refreshButton.SetIcon(qt.QApplication_Style().StandardIcon(qt.QStyle__SP_BrowserReload, nil, nil))
 	refreshButton.SetCheckable(false)
 	refreshButton.SetEnabled(true)
 	refreshButton.OnTriggered(func() {
-		if !b.currentStatus.Load() {
+		if !b.State().Alive() {
 			b.logger.Info("refresh failed,the service has down.")
 			return
 		}
 
-		for _, n := range nodes {
-			nn := n
-			go func() {
-				delay, _ := b.api.GetDelay(nn, b.config.Box.UrlTest, int(b.config.Box.MaxDelay))
-				b.proxies.UpdateDelay(nn, delay.Delay)
-			}()
+		if !b.Capabilities().Groups {
+			go b.refreshDelayEach(selector, nodes)
+			return
 		}
-		b.logger.Info("refresh delay finished")
+		go func() {
+			delays, err := b.api.GetGroupDelay(b.ctx, selector, b.config.Box.UrlTest, int(b.config.Box.MaxDelay))
+			if err != nil {
+				b.logger.Error("refresh delay failed", slog.String("selector", selector), slog.String("error", err.Error()))
+				return
+			}
+			b.proxies.UpdateDelays(nodes, delays)
+			b.logger.Info("refresh delay finished", slog.String("selector", selector))
+		}()
 	})
 	menu.AddAction(refreshButton)
 	menu.AddSeparator()
 
-	actionGroup := qt.NewQActionGroup(nil)
+	actionGroup := qt.NewQActionGroup(menu.QObject)
 	actionGroup.SetExclusive(true)
 	for _, v := range nodes {
 		if v == "" {
 			continue
 		}
-		act := qt.NewQAction2(v)
+		act := qt.NewQAction5(v, menu.QObject)
 		act.SetCheckable(true)
 		act.SetChecked(false)
 		act.SetText(v)
+		if provider := b.proxies.GetProvider(v); provider != "" {
+			act.SetToolTip(provider)
+		}
 		if v == now {
 			act.SetChecked(true)
 			act.SetDisabled(true)
 			act.SetText(gui.LatencyText(v, b.proxies.GetDelay(v)))
 		}
-		act.OnTriggered(func() {
-			if !b.currentStatus.Load() {
-				return
-			}
-			err := b.api.SwitchProxy(selector, v)
-			if err != nil {
-				b.logger.Error("switch proxy failed", slog.String("selector", selector), slog.String("target", v))
-				return
-			}
-			b.logger.Info("switch proxy finished", slog.String("selector", selector), slog.String("target", v))
-			for _, v := range actions {
-				v.SetEnabled(true)
-			}
+		if !selectable {
 			act.SetDisabled(true)
-		})
+		} else {
+			act.OnTriggered(func() {
+				if !b.State().Alive() {
+					restoreChecked(actions, now)
+					return
+				}
+				// the retries of the client must not freeze the tray, now and actions stay on the main thread
+				go func() {
+					err := b.api.SwitchProxy(b.ctx, selector, v)
+					if err != nil {
+						b.logger.Error("switch proxy failed", slog.String("selector", selector), slog.String("target", v), slog.String("error", err.Error()))
+					} else {
+						b.logger.Info("switch proxy finished", slog.String("selector", selector), slog.String("target", v))
+					}
+					mainthread.Start(func() {
+						if !*live {
+							return
+						}
+						if err != nil {
+							restoreChecked(actions, now)
+							return
+						}
+						for _, v := range actions {
+							v.SetEnabled(true)
+						}
+						act.SetDisabled(true)
+						now = v
+					})
+				}()
+			})
+		}
 		b.proxies.BindDelay(v, func(de uint16) {
 			b.logger.Debug("update delay", slog.String("selector", selector), slog.String("target", v))
 			mainthread.Wait(func() {
-				act.SetText(gui.LatencyText(v, de))
+				if *live {
+					act.SetText(gui.LatencyText(v, de))
+				}
 			})
 		})
 		// add
@@ -280,3 +576,35 @@ func (b *Box) addProxiesSelector(menu *qt.QMenu, nodes []string, now string) {
 		actions[v] = act
 	}
 }
+
+// restoreChecked moves the check back to now after a switch that did not happen
+func restoreChecked(actions map[string]*qt.QAction, now string) {
+	if act, ok := actions[now]; ok {
+		act.SetChecked(true)
+	}
+}
+
+// refreshDelayEach tests the nodes one by one with a few in flight,
+// for the cores without the group delay endpoint.
+func (b *Box) refreshDelayEach(selector string, nodes []string) {
+	const maxInFlight = 8
+	var wg sync.WaitGroup
+	limit := make(chan struct{}, maxInFlight)
+	for _, n := range nodes {
+		limit <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-limit
+				wg.Done()
+			}()
+			delay, err := b.api.GetDelay(b.ctx, n, b.config.Box.UrlTest, int(b.config.Box.MaxDelay))
+			if err != nil {
+				b.logger.Debug("delay test failed", slog.String("target", n), slog.String("error", err.Error()))
+			}
+			b.proxies.UpdateDelay(n, delay.Delay)
+		}()
+	}
+	wg.Wait()
+	b.logger.Info("refresh delay finished", slog.String("selector", selector))
+}
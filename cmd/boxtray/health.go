@@ -0,0 +1,220 @@
+package boxtray
+
+import (
+	"context"
+	"github.com/woshikedayaa/boxtray/common/capi"
+	"github.com/woshikedayaa/boxtray/common/gui"
+	"github.com/woshikedayaa/boxtray/config"
+	"sync"
+	"time"
+)
+
+// CoreState is the lifecycle of the core as seen from the tray
+type CoreState uint8
+
+const (
+	// CoreUnknown is the state before the first probe is sent
+	CoreUnknown CoreState = iota
+	// CoreConnecting waits for the answer of the first probe
+	CoreConnecting
+	CoreUp
+	// CoreDegraded was up, but failed the last probes, fewer of them than the failure threshold
+	CoreDegraded
+	// CoreUnauthorized answers, but rejects api.secret
+	CoreUnauthorized
+	CoreDown
+	// CoreStarting and CoreStopping follow the control commands until the probe agrees, or controlGrace passes
+	CoreStarting
+	CoreStopping
+)
+
+// controlGrace is how long the probe waits for a core to start or stop before it believes what it sees
+const controlGrace = 15 * time.Second
+
+// urlTestMargin is added to the timeout of a url_test probe, which is also the timeout of the test run by the core
+const urlTestMargin = time.Second
+
+func (s CoreState) String() string {
+	switch s {
+	case CoreConnecting:
+		return "connecting"
+	case CoreUp:
+		return "up"
+	case CoreDegraded:
+		return "degraded"
+	case CoreUnauthorized:
+		return "unauthorized"
+	case CoreDown:
+		return "down"
+	case CoreStarting:
+		return "starting"
+	case CoreStopping:
+		return "stopping"
+	default:
+		return "unknown"
+	}
+}
+
+// Alive reports whether the api serves requests in this state
+func (s CoreState) Alive() bool {
+	return s == CoreUp || s == CoreDegraded
+}
+
+// BoxStatus is a transition of the core state
+type BoxStatus struct {
+	Previous CoreState
+	State    CoreState
+	// Cause is the error that drove the transition, nil for a successful probe or a control action
+	Cause error
+	Time  time.Time
+}
+
+func (s BoxStatus) Up() bool {
+	return s.State.Alive()
+}
+
+// UpFromDown is true on the transition that makes the api usable again
+func (s BoxStatus) UpFromDown() bool {
+	return s.State.Alive() && !s.Previous.Alive()
+}
+
+// setUp tells a tray section whether to set itself up again for a live core, up is whether it already is.
+// Statuses can be dropped on the way, so the current state decides, and the transition only forces a restart of the core.
+func (b *Box) setUp(status BoxStatus, up bool) bool {
+	return b.State().Alive() && (!up || status.UpFromDown())
+}
+
+// coreHealth holds the current CoreState, every transition goes through it
+type coreHealth struct {
+	access sync.Mutex
+	state  CoreState
+	cause  string
+	since  time.Time
+
+	// failureThreshold and successThreshold are at least 1
+	failureThreshold int
+	successThreshold int
+	// failures and successes count the probes in a row
+	failures  int
+	successes int
+}
+
+func newCoreHealth(failureThreshold, successThreshold int) *coreHealth {
+	return &coreHealth{
+		failureThreshold: max(1, failureThreshold),
+		successThreshold: max(1, successThreshold),
+	}
+}
+
+func (h *coreHealth) Load() CoreState {
+	h.access.Lock()
+	defer h.access.Unlock()
+	return h.state
+}
+
+// set moves to state, ok is false when nothing changed.
+// Staying in the same state with a different cause, like a timeout after a refused connection, is a transition.
+func (h *coreHealth) set(state CoreState, cause error) (status BoxStatus, ok bool) {
+	h.access.Lock()
+	defer h.access.Unlock()
+	return h.setLocked(state, cause)
+}
+
+// setLocked must be called with access held
+func (h *coreHealth) setLocked(state CoreState, cause error) (status BoxStatus, ok bool) {
+	causeText := ""
+	if cause != nil {
+		causeText = gui.ErrorText(cause)
+	}
+	if h.state == state && h.cause == causeText {
+		return BoxStatus{}, false
+	}
+	now := time.Now()
+	status = BoxStatus{Previous: h.state, State: state, Cause: cause, Time: now}
+	h.state, h.cause, h.since = state, causeText, now
+	return status, true
+}
+
+// probed folds the result of a probe into the state.
+// A single failure only degrades a core that is up, and a down core needs successThreshold successes to be up again.
+func (h *coreHealth) probed(err error) (status BoxStatus, ok bool) {
+	h.access.Lock()
+	defer h.access.Unlock()
+	if err == nil {
+		h.failures, h.successes = 0, h.successes+1
+	} else {
+		h.failures, h.successes = h.failures+1, 0
+	}
+	settling := time.Since(h.since) < controlGrace
+	switch {
+	case err == nil:
+		if h.state == CoreStopping && settling {
+			return BoxStatus{}, false
+		}
+		// nothing to flap from before the first answer
+		if !h.state.Alive() && h.state != CoreConnecting && h.successes < h.successThreshold {
+			return BoxStatus{}, false
+		}
+		return h.setLocked(CoreUp, nil)
+	case capi.IsUnauthorized(err):
+		return h.setLocked(CoreUnauthorized, err)
+	case h.state == CoreStarting && settling:
+		return BoxStatus{}, false
+	case h.state.Alive() && h.failures < h.failureThreshold:
+		return h.setLocked(CoreDegraded, err)
+	default:
+		return h.setLocked(CoreDown, err)
+	}
+}
+
+// probe runs the probe configured in box.health once
+func (b *Box) probe(ctx context.Context) error {
+	health := b.config.Box.Health
+	timeout := time.Duration(health.Timeout) * time.Millisecond
+	if health.Probe == config.ProbeURLTest {
+		// the core gives up on the test after timeout, leave time for its answer
+		timeout += urlTestMargin
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var err error
+	switch health.Probe {
+	case config.ProbeProxies:
+		_, err = b.api.GetProxies(ctx)
+	case config.ProbeURLTest:
+		_, err = b.api.GetDelay(ctx, health.Proxy, b.config.Box.UrlTest, int(health.Timeout))
+	default:
+		var version capi.Version
+		version, err = b.api.GetVersion(ctx)
+		if err == nil {
+			b.setCapabilities(version)
+		}
+		return err
+	}
+	if err == nil && (!b.State().Alive() || b.capabilities.Load() == nil) {
+		// the other probes do not tell the flavor, ask once the core comes back
+		if version, versionErr := b.api.GetVersion(ctx); versionErr == nil {
+			b.setCapabilities(version)
+		}
+	}
+	return err
+}
+
+// nextProbe returns the delay before the probe after wait, it grows while the core is down
+func (b *Box) nextProbe(wait time.Duration) time.Duration {
+	interval := time.Duration(b.config.Box.Health.Interval) * time.Millisecond
+	switch b.State() {
+	case CoreDown, CoreUnauthorized:
+		return min(max(wait*2, interval), time.Duration(b.config.Box.Health.DownInterval)*time.Millisecond)
+	default:
+		return interval
+	}
+}
+
+// probeNow makes the publisher probe without waiting for the interval, after a control action
+func (b *Box) probeNow() {
+	select {
+	case b.probeWakeup <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,103 @@
+package boxtray
+
+import (
+	"errors"
+	"github.com/woshikedayaa/boxtray/common/capi"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+// a section that missed the up transition still sets up once it sees the core alive
+func TestSetUpAfterDroppedStatuses(t *testing.T) {
+	b := &Box{health: newCoreHealth(1, 1)}
+	b.health.set(CoreUp, nil)
+
+	degraded := BoxStatus{Previous: CoreUp, State: CoreDegraded}
+	if !b.setUp(degraded, false) {
+		t.Fatal("a section that is not set up must set up for a live core")
+	}
+	if b.setUp(degraded, true) {
+		t.Fatal("a section that is set up must stay as it is")
+	}
+	if !b.setUp(BoxStatus{Previous: CoreDown, State: CoreUp}, true) {
+		t.Fatal("a core that came back must set the section up again")
+	}
+
+	b.health.set(CoreDown, nil)
+	if b.setUp(BoxStatus{Previous: CoreDown, State: CoreUp}, false) {
+		t.Fatal("a stale up status must not set up a section for a down core")
+	}
+}
+
+func TestCoreHealthProbed(t *testing.T) {
+	failed := errors.New("connection refused")
+	unauthorized := &capi.APIError{Method: "GET", Path: "/version", StatusCode: http.StatusUnauthorized}
+	tests := []struct {
+		name                               string
+		state                              CoreState
+		failureThreshold, successThreshold int
+		// settled moves the last transition out of controlGrace
+		settled bool
+		probes  []error
+		want    CoreState
+	}{
+		{name: "first answer", state: CoreConnecting, successThreshold: 3, probes: []error{nil}, want: CoreUp},
+		{name: "first failure", state: CoreConnecting, failureThreshold: 3, probes: []error{failed}, want: CoreDown},
+		{name: "one failure degrades", state: CoreUp, failureThreshold: 3, probes: []error{failed}, want: CoreDegraded},
+		{name: "failure threshold", state: CoreUp, failureThreshold: 3, probes: []error{failed, failed, failed}, want: CoreDown},
+		{name: "success resets failures", state: CoreUp, failureThreshold: 2, probes: []error{failed, nil, failed}, want: CoreDegraded},
+		{name: "below success threshold", state: CoreDown, successThreshold: 2, probes: []error{nil}, want: CoreDown},
+		{name: "success threshold", state: CoreDown, successThreshold: 2, probes: []error{nil, nil}, want: CoreUp},
+		{name: "failure resets successes", state: CoreDown, successThreshold: 2, probes: []error{nil, failed, nil}, want: CoreDown},
+		{name: "unauthorized", state: CoreUp, failureThreshold: 3, probes: []error{unauthorized}, want: CoreUnauthorized},
+		{name: "unauthorized while down", state: CoreDown, probes: []error{unauthorized}, want: CoreUnauthorized},
+		{name: "stopping settles", state: CoreStopping, probes: []error{nil}, want: CoreStopping},
+		{name: "stopping settled", state: CoreStopping, settled: true, probes: []error{nil}, want: CoreUp},
+		{name: "stopped", state: CoreStopping, probes: []error{failed}, want: CoreDown},
+		{name: "starting settles", state: CoreStarting, probes: []error{failed}, want: CoreStarting},
+		{name: "starting settled", state: CoreStarting, settled: true, probes: []error{failed}, want: CoreDown},
+		{name: "started", state: CoreStarting, probes: []error{nil}, want: CoreUp},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newCoreHealth(test.failureThreshold, test.successThreshold)
+			h.set(test.state, nil)
+			if test.settled {
+				h.since = time.Now().Add(-controlGrace - time.Second)
+			}
+			for _, err := range test.probes {
+				h.probed(err)
+			}
+			if state := h.Load(); state != test.want {
+				t.Fatalf("state = %s, want %s", state, test.want)
+			}
+		})
+	}
+}
+
+func TestNextProbeBackoff(t *testing.T) {
+	b := &Box{health: newCoreHealth(1, 1)}
+	b.config.Box.Health.Interval = 1000
+	b.config.Box.Health.DownInterval = 5000
+
+	b.health.set(CoreUp, nil)
+	if wait := b.nextProbe(4 * time.Second); wait != time.Second {
+		t.Fatalf("wait while up = %s, want the interval", wait)
+	}
+
+	for _, state := range []CoreState{CoreDown, CoreUnauthorized} {
+		b.health.set(state, nil)
+		var waits []time.Duration
+		wait := time.Duration(0)
+		for range 5 {
+			wait = b.nextProbe(wait)
+			waits = append(waits, wait)
+		}
+		want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+		if !slices.Equal(waits, want) {
+			t.Fatalf("waits while %s = %v, want %v", state, waits, want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package boxtray
+
+import (
+	"context"
+	"github.com/woshikedayaa/boxtray/common/capi"
+	"github.com/woshikedayaa/boxtray/log"
+	"log/slog"
+	"time"
+)
+
+// startOnLaunch runs the start command, unless the first probe finds the core running
+func (b *Box) startOnLaunch() {
+	err := b.probe(b.ctx)
+	if b.ctx.Err() != nil {
+		return
+	}
+	if err == nil || capi.IsUnauthorized(err) {
+		b.logger.Info("core is running, skip start on launch")
+		return
+	}
+	if err := b.StartManually(); err != nil {
+		b.logger.Error("start on launch failed", slog.String("error", err.Error()))
+	}
+}
+
+// shutdown stops the box in order, all the steps share box.shutdown_timeout:
+// the core is stopped if control.stop_on_quit says so, the tray sections leave the bus,
+// then the probe and the streams are cancelled and waited for, and the logs are flushed.
+// Only the first call does anything.
+func (b *Box) shutdown() {
+	b.shutdownOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(b.config.Box.ShutdownTimeout)*time.Millisecond)
+		defer cancel()
+		b.logger.Info("shutdown now")
+
+		if b.config.Api.Control.StopOnQuit && len(b.config.Api.Control.Stop) != 0 && b.State() != CoreDown {
+			if err := b.stopCore(ctx); err != nil {
+				b.logger.Error("stop on quit failed", slog.String("error", err.Error()))
+			}
+		}
+
+		// the sections drop their stream subscriptions on the way out
+		b.bus.Close()
+		if err := b.bus.Wait(ctx); err != nil {
+			b.logger.Warn("subscribers did not leave in time", slog.String("error", err.Error()))
+		}
+
+		b.cancel()
+		select {
+		case <-b.publisherDone:
+		case <-ctx.Done():
+			b.logger.Warn("probe did not stop in time", slog.String("error", ctx.Err().Error()))
+		}
+		if err := b.hub.Wait(ctx); err != nil {
+			b.logger.Warn("streams did not end in time", slog.String("error", err.Error()))
+		}
+
+		b.logger.Info("shutdown finished")
+		log.Flush()
+	})
+}
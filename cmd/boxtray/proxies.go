@@ -18,14 +18,18 @@ type ProxiesManager struct {
 	//
 	selectors atomic.Pointer[orderedmap.OrderedMap[string, []*capi.Proxy]]
 	delays    *sync.Map // map[string]uint16
+	providers *sync.Map // map[string]string, node name to provider name
 	bind      *sync.Map
 	logger    *slog.Logger
+	// urlTest picks the delay history mihomo keeps per test url
+	urlTest string
 }
 
-func NewProxiesManager() *ProxiesManager {
-	p := &ProxiesManager{}
+func NewProxiesManager(urlTest string) *ProxiesManager {
+	p := &ProxiesManager{urlTest: urlTest}
 	p.selectors.Store(orderedmap.New[string, []*capi.Proxy]())
 	p.delays = &sync.Map{}
+	p.providers = &sync.Map{}
 	p.bind = &sync.Map{}
 	p.logger = log.Get("proxies-manager")
 	return p
@@ -40,10 +44,10 @@ func (p *ProxiesManager) Parse(proxies *capi.Proxies) error {
 	for pair := proxies.Proxies.Oldest(); pair != nil; pair = pair.Next() {
 		name := pair.Key
 		proxy := pair.Value
-		switch strings.ToLower(proxy.Type) {
-		case "":
+		switch {
+		case proxy.Type == "":
 			continue
-		case constant.TypeSelector, constant.TypeURLTest:
+		case constant.IsGroup(proxy.Type):
 			if len(proxy.All) == 0 {
 				p.logger.Warn("selector is empty,skip it", slog.String("selector", name))
 				continue
@@ -59,8 +63,8 @@ func (p *ProxiesManager) Parse(proxies *capi.Proxies) error {
 			}
 			selectors.Store(name, nodes)
 		default:
-			for _, his := range proxy.History {
-				delays[name] = his.Delay
+			if delay := proxy.DelayFor(p.urlTest); delay > 0 {
+				delays[name] = delay
 			}
 		}
 	}
@@ -79,6 +83,33 @@ func (p *ProxiesManager) Parse(proxies *capi.Proxies) error {
 	return nil
 }
 
+// ParseProviders attributes every node to the provider it comes from.
+// The "Compatible" provider holds the outbounds from the core config itself, so it is skipped.
+func (p *ProxiesManager) ParseProviders(providers *capi.ProxyProviders) {
+	p.providers.Clear()
+	for name, provider := range providers.Providers {
+		if strings.EqualFold(provider.VehicleType, constant.VehicleCompatible) {
+			continue
+		}
+		for _, node := range provider.Proxies {
+			if node == nil || node.Name == "" {
+				continue
+			}
+			p.providers.Store(node.Name, name)
+			if delay := node.DelayFor(p.urlTest); delay > 0 {
+				p.delays.Store(node.Name, delay)
+			}
+		}
+	}
+}
+
+func (p *ProxiesManager) GetProvider(name string) string {
+	if provider, ok := p.providers.Load(name); ok {
+		return provider.(string)
+	}
+	return ""
+}
+
 func (p *ProxiesManager) LoadSelector() *orderedmap.OrderedMap[string, []*capi.Proxy] {
 	return p.selectors.Load()
 }
@@ -97,9 +128,21 @@ func (p *ProxiesManager) BindDelay(name string, f func(de uint16)) {
 		})
 	}
 }
+
+// UnbindDelays drops every callback of BindDelay, before the actions they update go away
+func (p *ProxiesManager) UnbindDelays() {
+	p.bind.Clear()
+}
 func (p *ProxiesManager) UpdateDelay(name string, delay uint16) {
 	p.delays.Store(name, delay)
 	if f, ok := p.bind.Load(name); ok {
 		f.(func(uint16))(delay)
 	}
 }
+
+// UpdateDelays applies a whole group delay result, nodes absent from delays are marked as failed.
+func (p *ProxiesManager) UpdateDelays(nodes []string, delays map[string]uint16) {
+	for _, node := range nodes {
+		p.UpdateDelay(node, delays[node])
+	}
+}
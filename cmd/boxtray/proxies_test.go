@@ -0,0 +1,135 @@
+package boxtray
+
+import (
+	"context"
+	orderedmap "github.com/wk8/go-ordered-map/v2"
+	"github.com/woshikedayaa/boxtray/common/capi"
+	"github.com/woshikedayaa/boxtray/common/capi/capitest"
+	"github.com/woshikedayaa/boxtray/config"
+	"github.com/woshikedayaa/boxtray/log"
+	"slices"
+	"testing"
+	"time"
+)
+
+const testURL = "https://www.gstatic.com/generate_204"
+
+func newTestProxiesManager(t *testing.T) *ProxiesManager {
+	t.Helper()
+	if err := log.Init(config.LogConfig{Level: "error"}); err != nil {
+		t.Fatal(err)
+	}
+	return NewProxiesManager(testURL)
+}
+
+func history(delays ...uint16) []capi.History {
+	var h []capi.History
+	for _, delay := range delays {
+		h = append(h, capi.History{Time: time.Now(), Delay: delay})
+	}
+	return h
+}
+
+func TestProxiesManagerParse(t *testing.T) {
+	server := capitest.NewServer("")
+	defer server.Close()
+	alive, dead := true, false
+	server.SetProxies(
+		&capi.Proxy{Name: "a", Type: "Shadowsocks", History: history(300, 200)},
+		// mihomo keeps a history per test url, the one of testURL wins
+		&capi.Proxy{Name: "b", Type: "Vmess", History: history(900), Alive: &alive, Extra: map[string]*capi.ProxyExtra{
+			testURL: {Alive: true, History: history(150)},
+		}},
+		&capi.Proxy{Name: "c", Type: "Trojan", History: history(100), Alive: &dead},
+		&capi.Proxy{Name: "select", Type: "Selector", All: []string{"a", "b", "missing"}, Now: "b"},
+		&capi.Proxy{Name: "fallback", Type: "Fallback", All: []string{"c", "a"}, Now: "a"},
+		&capi.Proxy{Name: "empty", Type: "Selector"},
+	)
+	client, err := capi.NewClient(server.URL, &capi.ClientConfig{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxies, err := client.GetProxies(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestProxiesManager(t)
+	if err := p.Parse(proxies); err != nil {
+		t.Fatal(err)
+	}
+	var groups []string
+	for pair := p.LoadSelector().Oldest(); pair != nil; pair = pair.Next() {
+		groups = append(groups, pair.Key)
+	}
+	if !slices.Equal(groups, []string{"select", "fallback"}) {
+		t.Fatalf("groups = %v, want select and fallback in order", groups)
+	}
+	if nodes, _ := p.LoadSelector().Get("select"); len(nodes) != 2 {
+		t.Fatalf("select has %d nodes, the missing one must be skipped", len(nodes))
+	}
+	for name, want := range map[string]uint16{"a": 200, "b": 150, "c": 0, "select": 150, "fallback": 200} {
+		if delay := p.GetDelay(name); delay != want {
+			t.Errorf("delay of %s = %d, want %d", name, delay, want)
+		}
+	}
+
+	if err := p.Parse(&capi.Proxies{Proxies: orderedmap.New[string, *capi.Proxy]()}); err == nil {
+		t.Fatal("empty proxies must be rejected")
+	}
+}
+
+func TestProxiesManagerParseProviders(t *testing.T) {
+	p := newTestProxiesManager(t)
+	p.ParseProviders(&capi.ProxyProviders{Providers: map[string]*capi.ProxyProvider{
+		"default": {Name: "default", VehicleType: "Compatible", Proxies: []*capi.Proxy{{Name: "direct"}}},
+		"sub":     {Name: "sub", VehicleType: "HTTP", Proxies: []*capi.Proxy{{Name: "hk", History: history(80)}}},
+	}})
+	if provider := p.GetProvider("hk"); provider != "sub" {
+		t.Fatalf("provider of hk = %q, want sub", provider)
+	}
+	if provider := p.GetProvider("direct"); provider != "" {
+		t.Fatalf("provider of direct = %q, the compatible provider must be skipped", provider)
+	}
+	if delay := p.GetDelay("hk"); delay != 80 {
+		t.Fatalf("delay of hk = %d, want 80", delay)
+	}
+}
+
+func TestProxiesManagerUpdateDelays(t *testing.T) {
+	p := newTestProxiesManager(t)
+	var got []uint16
+	p.BindDelay("a", func(delay uint16) { got = append(got, delay) })
+	p.UpdateDelays([]string{"a", "b"}, map[string]uint16{"a": 42})
+	if !slices.Equal(got, []uint16{42}) {
+		t.Fatalf("bound delays = %v, want [42]", got)
+	}
+	if delay := p.GetDelay("b"); delay != 0 {
+		t.Fatalf("delay of b = %d, a node missing from the result failed", delay)
+	}
+}
+
+func TestProxiesManagerUnbindDelays(t *testing.T) {
+	p := newTestProxiesManager(t)
+	var calls []uint16
+	for range 2 {
+		// every build binds again, the callbacks of the last one must not pile up
+		p.UnbindDelays()
+		p.BindDelay("a", func(delay uint16) {
+			calls = append(calls, delay)
+		})
+	}
+	p.UpdateDelay("a", 100)
+	if !slices.Equal(calls, []uint16{100}) {
+		t.Fatalf("calls = %v, want [100]", calls)
+	}
+
+	p.UnbindDelays()
+	p.UpdateDelay("a", 200)
+	if len(calls) != 1 {
+		t.Fatalf("an unbound callback was called, calls = %v", calls)
+	}
+	if delay := p.GetDelay("a"); delay != 200 {
+		t.Fatalf("delay = %d, want 200", delay)
+	}
+}
@@ -0,0 +1,59 @@
+package boxtray
+
+import (
+	"github.com/woshikedayaa/boxtray/common"
+	"github.com/woshikedayaa/boxtray/common/capi"
+	"github.com/woshikedayaa/boxtray/config"
+	"net"
+	"net/http"
+	"os"
+)
+
+// Options are the command line switches that are not part of the config file
+type Options struct {
+	// Record is the file the controller session is written to
+	Record string
+	// Replay is a recorded session served in place of the controller
+	Replay string
+}
+
+func openRecorder(name string) (*capi.Recorder, *os.File, error) {
+	name, err := common.ExpandHomePath(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, nil, err
+	}
+	return capi.NewRecorder(file), file, nil
+}
+
+// startReplay serves the recording on a local port and returns the api config pointing to it,
+// the control commands are dropped so a replay never touches the real core.
+func startReplay(name string) (config.ApiConfig, error) {
+	name, err := common.ExpandHomePath(name)
+	if err != nil {
+		return config.ApiConfig{}, err
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return config.ApiConfig{}, err
+	}
+	entries, err := capi.ReadRecording(file)
+	_ = file.Close()
+	if err != nil {
+		return config.ApiConfig{}, err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return config.ApiConfig{}, err
+	}
+	go func() {
+		_ = http.Serve(listener, capi.NewReplayHandler(entries))
+	}()
+	return config.ApiConfig{
+		Scheme: "http",
+		Host:   listener.Addr().String(),
+	}, nil
+}
@@ -0,0 +1,10 @@
+package capi
+
+import (
+	"context"
+	"net/http"
+)
+
+func (c *Client) FlushFakeIPCache(ctx context.Context) error {
+	return c.doNoContent(ctx, http.MethodPost, "/cache/fakeip/flush", nil, nil)
+}
@@ -0,0 +1,110 @@
+package capi
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type Flavor uint8
+
+const (
+	FlavorUnknown Flavor = iota
+	FlavorSingBox
+	FlavorMihomo
+	FlavorClashPremium
+	FlavorClash
+)
+
+func (f Flavor) String() string {
+	switch f {
+	case FlavorSingBox:
+		return "sing-box"
+	case FlavorMihomo:
+		return "mihomo"
+	case FlavorClashPremium:
+		return "Clash Premium"
+	case FlavorClash:
+		return "Clash"
+	default:
+		return "unknown"
+	}
+}
+
+// Capabilities records which optional endpoints the core serves.
+// Providers are left out, some sing-box builds serve them too, a missing endpoint is just a 404.
+type Capabilities struct {
+	Flavor Flavor
+	// Major, Minor and Patch are zero when the version is not semver, like mihomo alpha builds
+	Major, Minor, Patch int
+
+	// Groups is /group/{name}/delay
+	Groups bool
+	// Reload is PUT /configs, sing-box accepts it but does nothing
+	Reload      bool
+	Restart     bool
+	Upgrade     bool
+	DNSQuery    bool
+	FakeIPFlush bool
+}
+
+var semverRegexp = regexp.MustCompile(`v?(\d+)\.(\d+)(?:\.(\d+))?`)
+
+func parseSemver(s string) (major, minor, patch int, ok bool) {
+	m := semverRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return 0, 0, 0, false
+	}
+	major, _ = strconv.Atoi(m[1])
+	minor, _ = strconv.Atoi(m[2])
+	if m[3] != "" {
+		patch, _ = strconv.Atoi(m[3])
+	}
+	return major, minor, patch, true
+}
+
+// DetectCapabilities decodes the /version answer of sing-box ("sing-box 1.11.0"),
+// mihomo ("v1.18.1" or "alpha-xxx" with meta set) and Clash Premium (a date with premium set).
+func DetectCapabilities(v Version) Capabilities {
+	c := Capabilities{}
+	switch {
+	case strings.HasPrefix(strings.ToLower(v.Version), "sing-box"):
+		c.Flavor = FlavorSingBox
+	case v.Meta:
+		c.Flavor = FlavorMihomo
+	case v.Premium:
+		c.Flavor = FlavorClashPremium
+	case v.Version != "":
+		c.Flavor = FlavorClash
+	}
+	if c.Flavor != FlavorClashPremium {
+		// premium versions are release dates, not semver
+		c.Major, c.Minor, c.Patch, _ = parseSemver(v.Version)
+	}
+
+	switch c.Flavor {
+	case FlavorSingBox:
+		c.Groups = true
+		c.DNSQuery = true
+		c.FakeIPFlush = true
+	case FlavorMihomo:
+		c.Groups = true
+		c.Reload = true
+		c.Restart = true
+		c.Upgrade = true
+		c.DNSQuery = true
+		c.FakeIPFlush = true
+	case FlavorClashPremium:
+		c.Reload = true
+		c.DNSQuery = true
+	case FlavorClash:
+		c.Reload = true
+	default:
+		// nothing is known, let every feature try and fail on its own
+		c.Groups = true
+		c.Reload = true
+		c.DNSQuery = true
+		c.FakeIPFlush = true
+	}
+	return c
+}
@@ -0,0 +1,416 @@
+// Package capitest provides an in-process fake Clash API controller for tests.
+package capitest
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	orderedmap "github.com/wk8/go-ordered-map/v2"
+	"github.com/woshikedayaa/boxtray/common/capi"
+	"github.com/woshikedayaa/boxtray/common/constant"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type failure struct {
+	status  int
+	message string
+}
+
+// Server is a scriptable controller, every setter is safe to call while clients are connected.
+type Server struct {
+	*httptest.Server
+
+	access   sync.Mutex
+	secret   string
+	latency  time.Duration
+	version  capi.Version
+	config   capi.Config
+	proxies  *orderedmap.OrderedMap[string, *capi.Proxy]
+	delays   map[string]uint16
+	failures map[string]failure
+	requests map[string]int
+	streams  map[string]map[*websocket.Conn]struct{}
+
+	upgrader websocket.Upgrader
+}
+
+// NewServer starts a controller guarded by secret, an empty secret disables the auth check.
+func NewServer(secret string) *Server {
+	s := &Server{
+		secret:   secret,
+		version:  capi.Version{Version: "sing-box 1.11.0"},
+		config:   capi.Config{Mode: "rule", ModeList: []string{"rule", "global", "direct"}, LogLevel: "info"},
+		proxies:  orderedmap.New[string, *capi.Proxy](),
+		delays:   make(map[string]uint16),
+		failures: make(map[string]failure),
+		requests: make(map[string]int),
+		streams:  make(map[string]map[*websocket.Conn]struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /version", s.handleVersion)
+	mux.HandleFunc("GET /proxies", s.handleProxies)
+	mux.HandleFunc("GET /proxies/{name}", s.handleProxy)
+	mux.HandleFunc("PUT /proxies/{name}", s.handleSwitchProxy)
+	mux.HandleFunc("GET /proxies/{name}/delay", s.handleDelay)
+	mux.HandleFunc("GET /group/{name}/delay", s.handleGroupDelay)
+	mux.HandleFunc("GET /configs", s.handleConfig)
+	mux.HandleFunc("PATCH /configs", s.handlePatchConfig)
+	mux.HandleFunc("GET /traffic", s.handleStream("traffic"))
+	mux.HandleFunc("GET /memory", s.handleStream("memory"))
+	s.Server = httptest.NewServer(s.middleware(mux))
+	return s
+}
+
+func (s *Server) Close() {
+	s.access.Lock()
+	for _, conns := range s.streams {
+		for conn := range conns {
+			conn.Close()
+		}
+	}
+	s.access.Unlock()
+	s.Server.Close()
+}
+
+func (s *Server) SetVersion(version capi.Version) {
+	s.access.Lock()
+	defer s.access.Unlock()
+	s.version = version
+}
+
+// SetProxies replaces every outbound, the order is kept like the real controller does
+func (s *Server) SetProxies(proxies ...*capi.Proxy) {
+	s.access.Lock()
+	defer s.access.Unlock()
+	s.proxies = orderedmap.New[string, *capi.Proxy]()
+	for _, proxy := range proxies {
+		cp := *proxy
+		s.proxies.Set(proxy.Name, &cp)
+	}
+}
+
+// SetDelay sets the result of the delay test of name, 0 marks the node dead.
+// A delay above the timeout of the test makes it time out, as the real controller does.
+func (s *Server) SetDelay(name string, delay uint16) {
+	s.access.Lock()
+	defer s.access.Unlock()
+	s.delays[name] = delay
+}
+
+func (s *Server) SetConfig(config capi.Config) {
+	s.access.Lock()
+	defer s.access.Unlock()
+	s.config = config
+}
+
+func (s *Server) Config() capi.Config {
+	s.access.Lock()
+	defer s.access.Unlock()
+	return s.config
+}
+
+// Now returns the selected outbound of a selector
+func (s *Server) Now(selector string) string {
+	s.access.Lock()
+	defer s.access.Unlock()
+	if proxy, ok := s.proxies.Get(selector); ok {
+		return proxy.Now
+	}
+	return ""
+}
+
+// SetLatency delays every response by d
+func (s *Server) SetLatency(d time.Duration) {
+	s.access.Lock()
+	defer s.access.Unlock()
+	s.latency = d
+}
+
+// Fail makes every request to path answer status with message until ClearFailures
+func (s *Server) Fail(path string, status int, message string) {
+	s.access.Lock()
+	defer s.access.Unlock()
+	s.failures[path] = failure{status: status, message: message}
+}
+
+func (s *Server) ClearFailures() {
+	s.access.Lock()
+	defer s.access.Unlock()
+	clear(s.failures)
+}
+
+// Requests returns how many requests reached path, including the rejected ones
+func (s *Server) Requests(path string) int {
+	s.access.Lock()
+	defer s.access.Unlock()
+	return s.requests[path]
+}
+
+// PushTraffic sends traffic to every connected /traffic stream
+func (s *Server) PushTraffic(traffic capi.Traffic) {
+	s.push("traffic", traffic)
+}
+
+// PushMemory sends memory to every connected /memory stream
+func (s *Server) PushMemory(memory capi.Memory) {
+	s.push("memory", memory)
+}
+
+// DropStreams closes every websocket connection, as a network blip would
+func (s *Server) DropStreams() {
+	s.access.Lock()
+	defer s.access.Unlock()
+	for _, conns := range s.streams {
+		for conn := range conns {
+			conn.Close()
+		}
+	}
+}
+
+func (s *Server) push(topic string, value any) {
+	bs, _ := json.Marshal(value)
+	s.access.Lock()
+	defer s.access.Unlock()
+	for conn := range s.streams[topic] {
+		_ = conn.SetWriteDeadline(time.Now().Add(time.Second))
+		if err := conn.WriteMessage(websocket.TextMessage, bs); err != nil {
+			conn.Close()
+			delete(s.streams[topic], conn)
+		}
+	}
+}
+
+func (s *Server) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.access.Lock()
+		s.requests[r.URL.Path]++
+		latency, secret := s.latency, s.secret
+		fail, failed := s.failures[r.URL.Path]
+		s.access.Unlock()
+
+		if latency > 0 {
+			select {
+			case <-time.After(latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if secret != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if token != secret && r.URL.Query().Get("token") != secret {
+				writeError(w, http.StatusUnauthorized, "Unauthorized")
+				return
+			}
+		}
+		if failed {
+			writeError(w, fail.status, fail.message)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleVersion(w http.ResponseWriter, _ *http.Request) {
+	s.access.Lock()
+	defer s.access.Unlock()
+	writeJSON(w, s.version)
+}
+
+func (s *Server) handleProxies(w http.ResponseWriter, _ *http.Request) {
+	s.access.Lock()
+	defer s.access.Unlock()
+	writeJSON(w, capi.Proxies{Proxies: s.proxies})
+}
+
+func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
+	s.access.Lock()
+	defer s.access.Unlock()
+	proxy, ok := s.proxies.Get(r.PathValue("name"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Resource not found")
+		return
+	}
+	writeJSON(w, proxy)
+}
+
+func (s *Server) handleSwitchProxy(w http.ResponseWriter, r *http.Request) {
+	body := struct {
+		Name string `json:"name"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Body invalid")
+		return
+	}
+	s.access.Lock()
+	defer s.access.Unlock()
+	proxy, ok := s.proxies.Get(r.PathValue("name"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Resource not found")
+		return
+	}
+	if constant.NormalizeProxyType(proxy.Type) != constant.TypeSelector {
+		writeError(w, http.StatusBadRequest, "Must be a Selector")
+		return
+	}
+	for _, node := range proxy.All {
+		if node == body.Name {
+			proxy.Now = body.Name
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	writeError(w, http.StatusBadRequest, "Selector update error: not found")
+}
+
+// delayOf must be called with access held, the status is the one the delay endpoint answers
+func (s *Server) delayOf(name string, timeout int) (uint16, int) {
+	delay := s.delays[name]
+	switch {
+	case delay == 0:
+		return 0, http.StatusServiceUnavailable
+	case timeout > 0 && int(delay) > timeout:
+		return 0, http.StatusGatewayTimeout
+	}
+	if proxy, ok := s.proxies.Get(name); ok {
+		proxy.History = append(proxy.History, capi.History{Time: time.Now(), Delay: delay})
+	}
+	return delay, http.StatusOK
+}
+
+func delayTimeout(r *http.Request) int {
+	timeout, _ := strconv.Atoi(r.URL.Query().Get("timeout"))
+	return timeout
+}
+
+func (s *Server) handleDelay(w http.ResponseWriter, r *http.Request) {
+	s.access.Lock()
+	defer s.access.Unlock()
+	name := r.PathValue("name")
+	if _, ok := s.proxies.Get(name); !ok {
+		writeError(w, http.StatusNotFound, "Resource not found")
+		return
+	}
+	delay, status := s.delayOf(name, delayTimeout(r))
+	switch status {
+	case http.StatusServiceUnavailable:
+		writeError(w, status, "An error occurred in the delay test")
+	case http.StatusGatewayTimeout:
+		writeError(w, status, "Timeout")
+	default:
+		writeJSON(w, capi.Delay{Delay: delay})
+	}
+}
+
+func (s *Server) handleGroupDelay(w http.ResponseWriter, r *http.Request) {
+	s.access.Lock()
+	defer s.access.Unlock()
+	group, ok := s.proxies.Get(r.PathValue("name"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Resource not found")
+		return
+	}
+	result := make(map[string]uint16)
+	for _, node := range group.All {
+		if delay, _ := s.delayOf(node, delayTimeout(r)); delay > 0 {
+			result[node] = delay
+		}
+	}
+	writeJSON(w, result)
+}
+
+func (s *Server) handleConfig(w http.ResponseWriter, _ *http.Request) {
+	s.access.Lock()
+	defer s.access.Unlock()
+	writeJSON(w, s.config)
+}
+
+func (s *Server) handlePatchConfig(w http.ResponseWriter, r *http.Request) {
+	patch := capi.ConfigPatch{}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeError(w, http.StatusBadRequest, "Body invalid")
+		return
+	}
+	s.access.Lock()
+	defer s.access.Unlock()
+	if patch.Mode != nil {
+		found := false
+		for _, mode := range s.config.ModeList {
+			found = found || strings.EqualFold(mode, *patch.Mode)
+		}
+		if !found {
+			writeError(w, http.StatusBadRequest, "Body invalid")
+			return
+		}
+		s.config.Mode = *patch.Mode
+	}
+	if patch.LogLevel != nil {
+		s.config.LogLevel = *patch.LogLevel
+	}
+	if patch.AllowLan != nil {
+		s.config.AllowLan = *patch.AllowLan
+	}
+	if patch.IPv6 != nil {
+		s.config.IPv6 = *patch.IPv6
+	}
+	for _, port := range []struct {
+		value  *int
+		target *int
+	}{
+		{patch.Port, &s.config.Port},
+		{patch.SocksPort, &s.config.SocksPort},
+		{patch.RedirPort, &s.config.RedirPort},
+		{patch.TProxyPort, &s.config.TProxyPort},
+		{patch.MixedPort, &s.config.MixedPort},
+	} {
+		if port.value != nil {
+			*port.target = *port.value
+		}
+	}
+	if patch.Tun != nil && patch.Tun.Enable != nil {
+		if s.config.Tun == nil {
+			s.config.Tun = make(map[string]any)
+		}
+		s.config.Tun["enable"] = *patch.Tun.Enable
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleStream(topic string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := s.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		s.access.Lock()
+		if s.streams[topic] == nil {
+			s.streams[topic] = make(map[*websocket.Conn]struct{})
+		}
+		s.streams[topic][conn] = struct{}{}
+		s.access.Unlock()
+		// drain control frames so pings get their pongs, until the client leaves
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				break
+			}
+		}
+		s.access.Lock()
+		delete(s.streams[topic], conn)
+		s.access.Unlock()
+		conn.Close()
+	}
+}
+
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
@@ -0,0 +1,105 @@
+package capi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+)
+
+const (
+	StreamTransportAuto      = "auto"
+	StreamTransportWebsocket = "websocket"
+	// StreamTransportHTTP reads the newline delimited json the api serves to plain http requests
+	StreamTransportHTTP = "http"
+)
+
+// chunkedIdleTimeout is how long a stream at ph may stay silent before it is considered dead, 0 means forever.
+// /traffic and /memory tick every second, /logs and /connections may be quiet for minutes,
+// so they rely on tcp keepalive and the context instead.
+func chunkedIdleTimeout(ph string) time.Duration {
+	switch path.Clean("/" + ph) {
+	case "/traffic", "/memory":
+		return streamPongWait
+	default:
+		return 0
+	}
+}
+
+func (c *Client) doGetChunkedStream(parentCtx context.Context, ph string, query url.Values) (<-chan []byte, <-chan error, error) {
+	ctx, cancel := context.WithCancel(parentCtx)
+	request, err := c.getRequest(ctx, http.MethodGet, c.newEndpoint(ph, query).String(), nil)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set("Cache-Control", "no-cache")
+	response, err := c.streamClient.Do(request)
+	if response != nil {
+		c.config.Recorder.stream(request.URL, response.StatusCode, err)
+	} else {
+		c.config.Recorder.stream(request.URL, 0, err)
+	}
+	if err != nil {
+		cancel()
+		return nil, nil, fmt.Errorf("failed to establish http stream: %w", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		defer response.Body.Close()
+		cancel()
+		return nil, nil, c.newAPIError(request, response)
+	}
+
+	reply := make(chan []byte, 16)
+	errChan := make(chan error, 1)
+	// there is no ping over plain http, a silent peer is considered dead after the idle timeout
+	idle := chunkedIdleTimeout(ph)
+	var watchdog *time.Timer
+	if idle > 0 {
+		watchdog = time.AfterFunc(idle, cancel)
+	}
+	go func() {
+		defer func() {
+			if watchdog != nil {
+				watchdog.Stop()
+			}
+			cancel()
+			response.Body.Close()
+			close(reply)
+			close(errChan)
+		}()
+
+		scanner := bufio.NewScanner(response.Body)
+		scanner.Buffer(make([]byte, 0, 4096), int(c.config.MaxResponseSize))
+		for scanner.Scan() {
+			if watchdog != nil {
+				watchdog.Reset(idle)
+			}
+			message := bytes.TrimSpace(scanner.Bytes())
+			if len(message) == 0 {
+				continue
+			}
+			c.config.Recorder.frame(ph, message)
+			select {
+			case <-ctx.Done():
+				return
+			case reply <- bytes.Clone(message):
+			}
+		}
+		if parentCtx.Err() != nil {
+			return
+		}
+		if err := scanner.Err(); err != nil && ctx.Err() == nil {
+			errChan <- err
+			return
+		}
+		errChan <- fmt.Errorf("http stream %s closed", ph)
+	}()
+
+	return reply, errChan, nil
+}
@@ -2,6 +2,7 @@ package capi
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/woshikedayaa/boxtray/common"
@@ -10,6 +11,7 @@ import (
 	"net/url"
 	"path"
 	"strconv"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,6 +19,10 @@ const (
 	minResponseSize = 1 << 16
 	minRequestSize
 	minTimout = 1 * time.Second
+
+	streamPingInterval = 10 * time.Second
+	streamPongWait     = 30 * time.Second
+	streamWriteWait    = 5 * time.Second
 )
 
 type ClientConfig struct {
@@ -24,6 +30,13 @@ type ClientConfig struct {
 	MaxRequestSize  int64
 	Timeout         time.Duration
 	Secret          string
+	TLS             *TLSConfig
+	// Retry is DefaultRetryPolicy when nil
+	Retry *RetryPolicy
+	// StreamTransport is one of StreamTransportAuto, StreamTransportWebsocket and StreamTransportHTTP
+	StreamTransport string
+	// Recorder writes down every request, response and stream frame when set
+	Recorder *Recorder
 }
 
 type Client struct {
@@ -32,6 +45,10 @@ type Client struct {
 	httpClient      *http.Client
 	config          *ClientConfig
 	websocketClient *websocket.Dialer
+	streamClient    *http.Client
+	breaker         *circuitBreaker
+	// streamOverHTTP is set once the websocket upgrade failed in auto mode
+	streamOverHTTP atomic.Bool
 }
 
 func NewClient(endpoint string, config *ClientConfig) (*Client, error) {
@@ -42,38 +59,87 @@ func NewClient(endpoint string, config *ClientConfig) (*Client, error) {
 	c.config.Timeout = max(minTimout, c.config.Timeout)
 	c.config.MaxResponseSize = max(minResponseSize, c.config.MaxResponseSize)
 	c.config.MaxRequestSize = max(minRequestSize, c.config.MaxRequestSize)
+	switch c.config.StreamTransport {
+	case "":
+		c.config.StreamTransport = StreamTransportAuto
+	case StreamTransportAuto, StreamTransportWebsocket, StreamTransportHTTP:
+	default:
+		return nil, fmt.Errorf("unknown stream transport: %s", c.config.StreamTransport)
+	}
+	if c.config.Retry == nil {
+		c.config.Retry = DefaultRetryPolicy()
+	}
+	c.breaker = &circuitBreaker{
+		threshold: c.config.Retry.BreakerThreshold,
+		cooldown:  c.config.Retry.BreakerCooldown,
+	}
 
 	endp, err := url.Parse(endpoint)
 	if err != nil {
 		return nil, err
 	}
-	if endp.Scheme != "http" && endp.Scheme != "https" {
-		return nil, fmt.Errorf("unexceped url scheme : %s", endp.Scheme)
-	}
 	if len(endp.Scheme) == 0 {
 		endp.Scheme = "http"
 	}
+	socketPath := ""
+	switch endp.Scheme {
+	case "http", "https":
+	case unixScheme:
+		socketPath, endp, err = unixEndpoint(endp)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unexceped url scheme : %s", endp.Scheme)
+	}
 	c.endpoint = endp
+	tlsConfig, err := c.config.TLS.build()
+	if err != nil {
+		return nil, err
+	}
 	// http httpClient
-	c.httpClient = &http.Client{
-
-		Transport: &http.Transport{
-			Proxy:               http.ProxyFromEnvironment,
-			TLSHandshakeTimeout: c.config.Timeout,
-		},
-		Timeout: c.config.Timeout,
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSHandshakeTimeout: c.config.Timeout,
+		TLSClientConfig:     tlsConfig,
 	}
 	// ws httpClient
 	c.websocketClient = &websocket.Dialer{
 		Proxy:            http.ProxyFromEnvironment,
 		HandshakeTimeout: c.config.Timeout,
+		TLSClientConfig:  tlsConfig,
+	}
+	if socketPath != "" {
+		transport.Proxy = nil
+		transport.DialContext = unixDialer(socketPath)
+		c.websocketClient.Proxy = nil
+		c.websocketClient.NetDialContext = unixDialer(socketPath)
+	}
+	c.httpClient = &http.Client{
+		Transport: transport,
+		Timeout:   c.config.Timeout,
+	}
+	if c.config.Recorder != nil {
+		c.config.Recorder.base = c.endpoint.Path
+		c.httpClient.Transport = &recordTransport{
+			next:            transport,
+			recorder:        c.config.Recorder,
+			maxRequestSize:  c.config.MaxRequestSize,
+			maxResponseSize: c.config.MaxResponseSize,
+		}
+	}
+	// streams live as long as their context, but a controller that never answers must not hold them forever
+	streamTransport := transport.Clone()
+	streamTransport.ResponseHeaderTimeout = c.config.Timeout
+	c.streamClient = &http.Client{
+		Transport: streamTransport,
 	}
 
 	return c, nil
 }
 
-func (c *Client) getRequest(method string, url string, body io.Reader) (*http.Request, error) {
-	request, err := http.NewRequestWithContext(context.Background(), method, url, body)
+func (c *Client) getRequest(ctx context.Context, method string, url string, body io.Reader) (*http.Request, error) {
+	request, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return nil, err
 	}
@@ -84,21 +150,21 @@ func (c *Client) getRequest(method string, url string, body io.Reader) (*http.Re
 	return request, nil
 }
 
-func (c *Client) doGet(ph string, arg url.Values) ([]byte, error) {
+func (c *Client) doGet(ctx context.Context, ph string, arg url.Values) ([]byte, error) {
 
-	request, err := c.getRequest(http.MethodGet, c.newEndpoint(ph, arg).String(), nil)
+	request, err := c.getRequest(ctx, http.MethodGet, c.newEndpoint(ph, arg).String(), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	response, err := c.httpClient.Do(request)
+	response, err := c.do(request)
 	if err != nil {
 		return nil, err
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", response.StatusCode)
+		return nil, c.newAPIError(request, response)
 	}
 
 	if contentLengthStr := response.Header.Get("Content-Length"); contentLengthStr != "" {
@@ -130,7 +196,59 @@ func (c *Client) doGet(ph string, arg url.Values) ([]byte, error) {
 	return body, nil
 }
 
+func (c *Client) doDelete(ctx context.Context, ph string) error {
+	return c.doNoContent(ctx, http.MethodDelete, ph, nil, nil)
+}
+
+func (c *Client) doPut(ctx context.Context, ph string, arg url.Values, body io.Reader) error {
+	return c.doNoContent(ctx, http.MethodPut, ph, arg, body)
+}
+
+// doNoContent sends a request whose response carries no useful body
+func (c *Client) doNoContent(ctx context.Context, method string, ph string, arg url.Values, body io.Reader) error {
+	request, err := c.getRequest(ctx, method, c.newEndpoint(ph, arg).String(), body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	response, err := c.do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNoContent || response.StatusCode == http.StatusOK {
+		return nil
+	}
+	return c.newAPIError(request, response)
+}
+
+// doGetStream follows a streaming endpoint over the configured transport,
+// in auto mode it switches to chunked http for good once the websocket upgrade is refused.
 func (c *Client) doGetStream(ctx context.Context, ph string, query url.Values) (<-chan []byte, <-chan error, error) {
+	switch c.config.StreamTransport {
+	case StreamTransportHTTP:
+		return c.doGetChunkedStream(ctx, ph, query)
+	case StreamTransportWebsocket:
+		return c.doGetWebsocketStream(ctx, ph, query)
+	}
+	if c.streamOverHTTP.Load() {
+		return c.doGetChunkedStream(ctx, ph, query)
+	}
+	reply, errChan, err := c.doGetWebsocketStream(ctx, ph, query)
+	if err != nil && errors.Is(err, websocket.ErrBadHandshake) {
+		reply, errChan, httpErr := c.doGetChunkedStream(ctx, ph, query)
+		if httpErr != nil {
+			return nil, nil, err
+		}
+		c.streamOverHTTP.Store(true)
+		return reply, errChan, nil
+	}
+	return reply, errChan, err
+}
+
+func (c *Client) doGetWebsocketStream(ctx context.Context, ph string, query url.Values) (<-chan []byte, <-chan error, error) {
 	header := http.Header{}
 	if len(c.config.Secret) > 0 {
 		header.Set("Authorization", fmt.Sprintf("Bearer %s", c.config.Secret))
@@ -147,42 +265,76 @@ func (c *Client) doGetStream(ctx context.Context, ph string, query url.Values) (
 	default:
 	}
 	conn, resp, err := c.websocketClient.DialContext(ctx, endpoint.String(), header)
+	if resp != nil {
+		c.config.Recorder.stream(endpoint, resp.StatusCode, err)
+	} else {
+		c.config.Recorder.stream(endpoint, 0, err)
+	}
 	if err != nil {
 		if resp != nil && resp.Body != nil {
-			resp.Body.Close()
+			defer resp.Body.Close()
+			// a 2xx means something in between dropped the upgrade, keep ErrBadHandshake for the fallback
+			if resp.StatusCode >= http.StatusBadRequest {
+				err = c.newAPIError(resp.Request, resp)
+			}
 		}
 		return nil, nil, fmt.Errorf("failed to establish WebSocket connection: %w", err)
 	}
 
 	reply := make(chan []byte, 16)
 	errChan := make(chan error, 1)
+	done := make(chan struct{})
+	// a dead peer is detected by the read deadline, pongs and messages push it forward
+	_ = conn.SetReadDeadline(time.Now().Add(streamPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(streamPongWait))
+	})
+	go func() {
+		ticker := time.NewTicker(streamPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				// unblock ReadMessage
+				conn.Close()
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteWait)); err != nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
 	go func() {
 		defer func() {
+			close(done)
 			close(reply)
 			close(errChan)
 			conn.Close()
 		}()
 
 		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				_, message, err := conn.ReadMessage()
-				if err != nil {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				if ctx.Err() == nil {
 					errChan <- err
-					return
 				}
+				return
+			}
+			_ = conn.SetReadDeadline(time.Now().Add(streamPongWait))
+			c.config.Recorder.frame(ph, message)
 
-				if len(message) == 0 {
-					continue
-				}
+			if len(message) == 0 {
+				continue
+			}
 
-				select {
-				case <-ctx.Done():
-					return
-				case reply <- message:
-				}
+			select {
+			case <-ctx.Done():
+				return
+			case reply <- message:
 			}
 		}
 	}()
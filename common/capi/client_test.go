@@ -0,0 +1,179 @@
+package capi_test
+
+import (
+	"context"
+	"github.com/woshikedayaa/boxtray/common/capi"
+	"github.com/woshikedayaa/boxtray/common/capi/capitest"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, server *capitest.Server, secret string) *capi.Client {
+	t.Helper()
+	client, err := capi.NewClient(server.URL, &capi.ClientConfig{
+		Timeout: 2 * time.Second,
+		Secret:  secret,
+		Retry:   &capi.RetryPolicy{Attempts: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestGetVersionUnauthorized(t *testing.T) {
+	server := capitest.NewServer("secret")
+	defer server.Close()
+
+	if _, err := newTestClient(t, server, "").GetVersion(context.Background()); !capi.IsUnauthorized(err) {
+		t.Fatalf("err = %v, want unauthorized", err)
+	}
+	version, err := newTestClient(t, server, "secret").GetVersion(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version.Version != "sing-box 1.11.0" {
+		t.Fatalf("version = %q", version.Version)
+	}
+}
+
+func TestGetProxiesNormalizesTypes(t *testing.T) {
+	server := capitest.NewServer("")
+	defer server.Close()
+	// mihomo spells its types this way
+	server.SetProxies(
+		&capi.Proxy{Name: "ss", Type: "Shadowsocks"},
+		&capi.Proxy{Name: "socks", Type: "Socks5"},
+		&capi.Proxy{Name: "reject", Type: "Reject"},
+		&capi.Proxy{Name: "select", Type: "Selector", All: []string{"ss", "socks"}, Now: "ss"},
+	)
+
+	proxies, err := newTestClient(t, server, "").GetProxies(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"ss": "shadowsocks", "socks": "socks", "reject": "block", "select": "selector"} {
+		proxy, ok := proxies.Proxies.Get(name)
+		if !ok {
+			t.Fatalf("%s is missing", name)
+		}
+		if proxy.Type != want {
+			t.Errorf("%s type = %q, want %q", name, proxy.Type, want)
+		}
+	}
+	if first := proxies.Proxies.Oldest().Key; first != "ss" {
+		t.Errorf("first proxy = %q, the order must be kept", first)
+	}
+}
+
+func TestSwitchProxy(t *testing.T) {
+	server := capitest.NewServer("")
+	defer server.Close()
+	server.SetProxies(
+		&capi.Proxy{Name: "a", Type: "direct"},
+		&capi.Proxy{Name: "b", Type: "direct"},
+		&capi.Proxy{Name: "select", Type: "selector", All: []string{"a", "b"}, Now: "a"},
+		&capi.Proxy{Name: "auto", Type: "urltest", All: []string{"a", "b"}, Now: "a"},
+	)
+	client := newTestClient(t, server, "")
+
+	if err := client.SwitchProxy(context.Background(), "select", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if now := server.Now("select"); now != "b" {
+		t.Fatalf("now = %q, want b", now)
+	}
+	if err := client.SwitchProxy(context.Background(), "select", "c"); err == nil {
+		t.Fatal("switching to a missing node must fail")
+	}
+	if err := client.SwitchProxy(context.Background(), "auto", "b"); err == nil {
+		t.Fatal("switching a urltest group must fail")
+	}
+}
+
+func TestGetDelay(t *testing.T) {
+	server := capitest.NewServer("")
+	defer server.Close()
+	server.SetProxies(
+		&capi.Proxy{Name: "fast", Type: "direct"},
+		&capi.Proxy{Name: "slow", Type: "direct"},
+		&capi.Proxy{Name: "dead", Type: "direct"},
+		&capi.Proxy{Name: "group", Type: "selector", All: []string{"fast", "slow", "dead"}},
+	)
+	server.SetDelay("fast", 120)
+	server.SetDelay("slow", 900)
+	client := newTestClient(t, server, "")
+
+	delay, err := client.GetDelay(context.Background(), "fast", "", 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delay.Delay != 120 {
+		t.Fatalf("delay = %d, want 120", delay.Delay)
+	}
+
+	_, err = client.GetDelay(context.Background(), "slow", "", 500)
+	if !capi.IsTimeout(err) {
+		t.Fatalf("err = %v, want a timeout", err)
+	}
+
+	_, err = client.GetDelay(context.Background(), "dead", "", 500)
+	if err == nil || capi.IsTimeout(err) {
+		t.Fatalf("err = %v, want a failed delay test", err)
+	}
+	if apiErr, ok := err.(*capi.APIError); !ok || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("err = %v, want status 503", err)
+	}
+
+	delays, err := client.GetGroupDelay(context.Background(), "group", "", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delays) != 2 || delays["fast"] != 120 || delays["slow"] != 900 {
+		t.Fatalf("delays = %v, want fast and slow only", delays)
+	}
+}
+
+func TestPatchConfig(t *testing.T) {
+	server := capitest.NewServer("")
+	defer server.Close()
+	client := newTestClient(t, server, "")
+
+	if err := client.SetMode(context.Background(), "global"); err != nil {
+		t.Fatal(err)
+	}
+	if mode := server.Config().Mode; mode != "global" {
+		t.Fatalf("mode = %q, want global", mode)
+	}
+	if err := client.SetMode(context.Background(), "nope"); err == nil {
+		t.Fatal("an unknown mode must be rejected")
+	}
+
+	allowLan := true
+	if err := client.PatchConfig(context.Background(), capi.ConfigPatch{AllowLan: &allowLan}); err != nil {
+		t.Fatal(err)
+	}
+	config, err := client.GetConfig(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.AllowLan || config.Mode != "global" {
+		t.Fatalf("config = %+v, want allow-lan on and mode untouched", config)
+	}
+}
+
+func TestCloseConnectionEmptyID(t *testing.T) {
+	server := capitest.NewServer("")
+	defer server.Close()
+	client, err := capi.NewClient(server.URL, &capi.ClientConfig{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CloseConnection(context.Background(), ""); err == nil {
+		t.Fatal("an empty id must be rejected")
+	}
+	if requests := server.Requests("/connections"); requests != 0 {
+		t.Fatalf("an empty id reached DELETE /connections %d times", requests)
+	}
+}
@@ -1,10 +1,13 @@
 package capi
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
+	"net/url"
+	"strconv"
 )
 
 type Config struct {
@@ -23,8 +26,26 @@ type Config struct {
 	Tun      map[string]any `json:"tun"`
 }
 
-func (c *Client) GetConfig() (*Config, error) {
-	bs, err := c.doGet("/config", nil)
+type TunPatch struct {
+	Enable *bool `json:"enable,omitempty"`
+}
+
+// ConfigPatch holds the runtime switchable settings, nil fields are left untouched by the core.
+type ConfigPatch struct {
+	Port       *int      `json:"port,omitempty"`
+	SocksPort  *int      `json:"socks-port,omitempty"`
+	RedirPort  *int      `json:"redir-port,omitempty"`
+	TProxyPort *int      `json:"tproxy-port,omitempty"`
+	MixedPort  *int      `json:"mixed-port,omitempty"`
+	AllowLan   *bool     `json:"allow-lan,omitempty"`
+	Mode       *string   `json:"mode,omitempty"`
+	LogLevel   *string   `json:"log-level,omitempty"`
+	IPv6       *bool     `json:"ipv6,omitempty"`
+	Tun        *TunPatch `json:"tun,omitempty"`
+}
+
+func (c *Client) GetConfig(ctx context.Context) (*Config, error) {
+	bs, err := c.doGet(ctx, "/configs", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -36,18 +57,27 @@ func (c *Client) GetConfig() (*Config, error) {
 	return cfg, nil
 }
 
-func (c *Client) SetMode(mode string) error {
-	if len(mode) == 0 {
-		return fmt.Errorf("mode str can not be empty")
-	}
-	req, err := http.NewRequest(http.MethodPatch, c.newEndpoint("/config", nil).String(), strings.NewReader(fmt.Sprintf("{\"mode\":\"%s\"}", mode)))
+func (c *Client) PatchConfig(ctx context.Context, patch ConfigPatch) error {
+	bs, err := json.Marshal(patch)
 	if err != nil {
 		return err
 	}
-	resp, err := c.httpClient.Do(req)
+	return c.doNoContent(ctx, http.MethodPatch, "/configs", nil, bytes.NewReader(bs))
+}
+
+func (c *Client) SetMode(ctx context.Context, mode string) error {
+	if len(mode) == 0 {
+		return fmt.Errorf("mode str can not be empty")
+	}
+	return c.PatchConfig(ctx, ConfigPatch{Mode: &mode})
+}
+
+// ReloadConfig asks the core to load the config file at path,
+// an empty path reloads the file the core was started with.
+func (c *Client) ReloadConfig(ctx context.Context, path string, force bool) error {
+	bs, err := json.Marshal(map[string]string{"path": path})
 	if err != nil {
 		return err
 	}
-	resp.Body.Close()
-	return nil
+	return c.doPut(ctx, "/configs", url.Values{"force": []string{strconv.FormatBool(force)}}, bytes.NewReader(bs))
 }
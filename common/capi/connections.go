@@ -0,0 +1,67 @@
+package capi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+)
+
+type ConnectionMetadata struct {
+	Network         string `json:"network"`
+	Type            string `json:"type"`
+	SourceIP        string `json:"sourceIP"`
+	DestinationIP   string `json:"destinationIP"`
+	SourcePort      string `json:"sourcePort"`
+	DestinationPort string `json:"destinationPort"`
+	Host            string `json:"host"`
+	DNSMode         string `json:"dnsMode"`
+	ProcessPath     string `json:"processPath"`
+}
+
+type Connection struct {
+	ID          string             `json:"id"`
+	Metadata    ConnectionMetadata `json:"metadata"`
+	Upload      int64              `json:"upload"`
+	Download    int64              `json:"download"`
+	Start       time.Time          `json:"start"`
+	Chains      []string           `json:"chains"`
+	Rule        string             `json:"rule"`
+	RulePayload string             `json:"rulePayload"`
+}
+
+type Connections struct {
+	DownloadTotal int64        `json:"downloadTotal"`
+	UploadTotal   int64        `json:"uploadTotal"`
+	Connections   []Connection `json:"connections"`
+}
+
+func (c *Client) GetConnections(ctx context.Context) (*Connections, error) {
+	bs, err := c.doGet(ctx, "/connections", nil)
+	if err != nil {
+		return nil, err
+	}
+	conns := &Connections{}
+	err = json.Unmarshal(bs, conns)
+	if err != nil {
+		return nil, err
+	}
+	return conns, nil
+}
+
+func (c *Client) StreamConnections(parentCtx context.Context, handler func(connections *Connections, stop context.CancelFunc), options *StreamOptions) error {
+	return runJSONStream(c, parentCtx, "/connections", nil, options, handler)
+}
+
+// CloseConnection closes the connection id, an empty id is an error as it would close all of them, see CloseAllConnections
+func (c *Client) CloseConnection(ctx context.Context, id string) error {
+	if id == "" {
+		return fmt.Errorf("connection id can not be empty")
+	}
+	return c.doDelete(ctx, path.Join("connections", id))
+}
+
+func (c *Client) CloseAllConnections(ctx context.Context) error {
+	return c.doDelete(ctx, "/connections")
+}
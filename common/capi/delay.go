@@ -1,6 +1,7 @@
 package capi
 
 import (
+	"context"
 	"encoding/json"
 	"path"
 	"strconv"
@@ -10,14 +11,14 @@ type Delay struct {
 	Delay uint16 `json:"delay"`
 }
 
-func (c *Client) GetDelay(target string, url string, timeout int) (Delay, error) {
+func (c *Client) GetDelay(ctx context.Context, target string, url string, timeout int) (Delay, error) {
 	if url == "" {
 		url = "https://google.com/generate_204"
 	}
 	if timeout <= 0 {
 		timeout = 500
 	}
-	bs, err := c.doGet(path.Join("proxies", target, "delay"), map[string][]string{
+	bs, err := c.doGet(ctx, path.Join("proxies", target, "delay"), map[string][]string{
 		"url":     []string{url},
 		"timeout": []string{strconv.FormatInt(int64(timeout), 10)},
 	})
@@ -31,3 +32,27 @@ func (c *Client) GetDelay(target string, url string, timeout int) (Delay, error)
 	}
 	return d, nil
 }
+
+// GetGroupDelay tests every node of the group on the core side,
+// nodes that failed the test are missing from the result.
+func (c *Client) GetGroupDelay(ctx context.Context, group string, url string, timeout int) (map[string]uint16, error) {
+	if url == "" {
+		url = "https://google.com/generate_204"
+	}
+	if timeout <= 0 {
+		timeout = 500
+	}
+	bs, err := c.doGet(ctx, path.Join("group", group, "delay"), map[string][]string{
+		"url":     []string{url},
+		"timeout": []string{strconv.FormatInt(int64(timeout), 10)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	d := make(map[string]uint16)
+	err = json.Unmarshal(bs, &d)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
@@ -0,0 +1,67 @@
+package capi
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+)
+
+type DNSQuestion struct {
+	Name  string `json:"Name"`
+	Qtype uint16 `json:"Qtype"`
+}
+
+type DNSRecord struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+	TTL  uint32 `json:"TTL"`
+	Data string `json:"data"`
+}
+
+type DNSQuery struct {
+	Status   int           `json:"Status"`
+	Question []DNSQuestion `json:"Question"`
+	Answer   []DNSRecord   `json:"Answer"`
+}
+
+var dnsTypeNames = map[uint16]string{
+	1:  "A",
+	2:  "NS",
+	5:  "CNAME",
+	6:  "SOA",
+	12: "PTR",
+	15: "MX",
+	16: "TXT",
+	28: "AAAA",
+	33: "SRV",
+	64: "SVCB",
+	65: "HTTPS",
+}
+
+func DNSTypeName(t uint16) string {
+	if name, ok := dnsTypeNames[t]; ok {
+		return name
+	}
+	return "TYPE" + strconv.FormatUint(uint64(t), 10)
+}
+
+// QueryDNS resolves name with the core's dns, qtype is the record type name like "A" or "AAAA"
+func (c *Client) QueryDNS(ctx context.Context, name string, qtype string) (*DNSQuery, error) {
+	if qtype == "" {
+		qtype = "A"
+	}
+	bs, err := c.doGet(ctx, "/dns/query", url.Values{
+		"name": []string{name},
+		"type": []string{qtype},
+	})
+	if err != nil {
+		return nil, err
+	}
+	q := &DNSQuery{}
+	err = json.Unmarshal(bs, q)
+	if err != nil {
+		return nil, err
+	}
+	return q, nil
+}
@@ -0,0 +1,79 @@
+package capi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// APIError is returned when the core answers with an unexpected status code.
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	// Message is the "message" field the core puts in its error body, may be empty
+	Message string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s %s: unexpected status code: %d", e.Method, e.Path, e.StatusCode)
+	}
+	return fmt.Sprintf("%s %s: unexpected status code: %d: %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+func (c *Client) newAPIError(request *http.Request, response *http.Response) *APIError {
+	e := &APIError{StatusCode: response.StatusCode}
+	if request != nil {
+		e.Method = request.Method
+		e.Path = request.URL.Path
+	}
+	bs, err := io.ReadAll(io.LimitReader(response.Body, c.config.MaxResponseSize))
+	if err != nil {
+		return e
+	}
+	body := struct {
+		Message string `json:"message"`
+	}{}
+	if json.Unmarshal(bs, &body) == nil {
+		e.Message = body.Message
+	}
+	return e
+}
+
+func statusOf(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// IsUnauthorized reports whether the core rejected the secret.
+func IsUnauthorized(err error) bool {
+	code := statusOf(err)
+	return code == http.StatusUnauthorized || code == http.StatusForbidden
+}
+
+func IsNotFound(err error) bool {
+	return statusOf(err) == http.StatusNotFound
+}
+
+// IsTimeout reports whether the request timed out, either on our side or on the core side.
+func IsTimeout(err error) bool {
+	if err == nil {
+		return false
+	}
+	if code := statusOf(err); code == http.StatusRequestTimeout || code == http.StatusGatewayTimeout {
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,54 @@
+package capi
+
+import (
+	"context"
+	"github.com/woshikedayaa/boxtray/common/event"
+)
+
+// Hub keeps one stream per endpoint for all the consumers of the process.
+// A stream starts with the first subscriber of its topic and stops after the last one leaves,
+// subscribe with event.DropOldest to always get the latest value.
+type Hub struct {
+	Traffic     *event.Topic[Traffic]
+	Memory      *event.Topic[Memory]
+	Connections *event.Topic[*Connections]
+
+	bus *event.Bus
+}
+
+// Wait blocks until the streams stopped by the last unsubscriptions are gone, or ctx is done.
+func (h *Hub) Wait(ctx context.Context) error {
+	return h.bus.WaitSources(ctx)
+}
+
+// NewHub creates the topics, onState reports the stream state of each topic and may be nil.
+func (c *Client) NewHub(onState func(topic string, state StreamState, err error)) *Hub {
+	if onState == nil {
+		onState = func(string, StreamState, error) {}
+	}
+	options := func(topic string) *StreamOptions {
+		return &StreamOptions{OnState: func(state StreamState, err error) {
+			onState(topic, state, err)
+		}}
+	}
+	// report the error a stream gave up with
+	exit := func(topic string, err error) error {
+		if err != nil {
+			onState(topic, StreamClosed, err)
+		}
+		return err
+	}
+	bus := event.NewBus()
+	return &Hub{
+		bus: bus,
+		Traffic: event.NewSourceTopic(bus, "traffic", func(ctx context.Context, emit func(Traffic)) error {
+			return exit("traffic", c.GetTraffic(ctx, func(traffic Traffic, _ context.CancelFunc) { emit(traffic) }, options("traffic")))
+		}),
+		Memory: event.NewSourceTopic(bus, "memory", func(ctx context.Context, emit func(Memory)) error {
+			return exit("memory", c.GetMemory(ctx, func(memory Memory, _ context.CancelFunc) { emit(memory) }, options("memory")))
+		}),
+		Connections: event.NewSourceTopic(bus, "connections", func(ctx context.Context, emit func(*Connections)) error {
+			return exit("connections", c.StreamConnections(ctx, func(connections *Connections, _ context.CancelFunc) { emit(connections) }, options("connections")))
+		}),
+	}
+}
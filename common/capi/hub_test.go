@@ -0,0 +1,165 @@
+package capi_test
+
+import (
+	"context"
+	"github.com/woshikedayaa/boxtray/common/capi"
+	"github.com/woshikedayaa/boxtray/common/capi/capitest"
+	"github.com/woshikedayaa/boxtray/common/event"
+	"testing"
+	"time"
+)
+
+func newTestHub(t *testing.T) (*capitest.Server, *capi.Hub) {
+	t.Helper()
+	server := capitest.NewServer("")
+	t.Cleanup(server.Close)
+	client, err := capi.NewClient(server.URL, &capi.ClientConfig{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, client.NewHub(nil)
+}
+
+// pushUntil pushes traffic until every subscription got it, the server learns about a stream a bit after the client
+func pushUntil(t *testing.T, server *capitest.Server, traffic capi.Traffic, subscriptions ...*event.Subscription[capi.Traffic]) {
+	t.Helper()
+	deadline := time.After(2 * time.Second)
+	for _, s := range subscriptions {
+		for received := false; !received; {
+			server.PushTraffic(traffic)
+			select {
+			case value, ok := <-s.C:
+				if !ok {
+					t.Fatal("the subscription ended")
+				}
+				received = value == traffic
+			case <-time.After(10 * time.Millisecond):
+			case <-deadline:
+				t.Fatalf("%+v did not arrive", traffic)
+			}
+		}
+	}
+}
+
+func waitHub(t *testing.T, hub *capi.Hub) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := hub.Wait(ctx); err != nil {
+		t.Fatalf("the stream did not stop: %v", err)
+	}
+}
+
+func TestHubSharesOneStream(t *testing.T) {
+	server, hub := newTestHub(t)
+	if requests := server.Requests("/traffic"); requests != 0 {
+		t.Fatalf("%d streams before the first subscriber", requests)
+	}
+
+	first := hub.Traffic.Subscribe(context.Background(), event.Options{Buffer: 8})
+	pushUntil(t, server, capi.Traffic{Up: 1}, first)
+	if requests := server.Requests("/traffic"); requests != 1 {
+		t.Fatalf("the first subscriber opened %d streams, want 1", requests)
+	}
+
+	// a late subscriber joins the running stream
+	second := hub.Traffic.Subscribe(context.Background(), event.Options{Buffer: 8})
+	pushUntil(t, server, capi.Traffic{Up: 2}, first, second)
+	if requests := server.Requests("/traffic"); requests != 1 {
+		t.Fatalf("the late subscriber opened a stream, %d in total", requests)
+	}
+	if requests := server.Requests("/memory"); requests != 0 {
+		t.Fatalf("the memory stream started without a subscriber")
+	}
+
+	first.Close()
+	pushUntil(t, server, capi.Traffic{Up: 3}, second)
+	second.Close()
+	waitHub(t, hub)
+
+	// the last one left, the next subscriber starts over
+	third := hub.Traffic.Subscribe(context.Background(), event.Options{Buffer: 8})
+	defer third.Close()
+	pushUntil(t, server, capi.Traffic{Up: 4}, third)
+	if requests := server.Requests("/traffic"); requests != 2 {
+		t.Fatalf("%d streams, want a new one after the last subscriber left", requests)
+	}
+}
+
+func TestHubCanceledSubscriberStopsStream(t *testing.T) {
+	server, hub := newTestHub(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := hub.Traffic.Subscribe(ctx, event.Options{Buffer: 8})
+	defer s.Close()
+	pushUntil(t, server, capi.Traffic{Up: 1}, s)
+
+	cancel()
+	for range s.C {
+	}
+	waitHub(t, hub)
+}
+
+func TestHubSlowSubscriberKeepsLatest(t *testing.T) {
+	server, hub := newTestHub(t)
+	slow := hub.Traffic.Subscribe(context.Background(), event.Options{Buffer: 1, Policy: event.DropOldest})
+	defer slow.Close()
+	fast := hub.Traffic.Subscribe(context.Background(), event.Options{Buffer: 8})
+	defer fast.Close()
+	pushUntil(t, server, capi.Traffic{Up: 1}, fast)
+	// the stream is up, values are pushed once from here on, and what fast got slow got too
+	push := func(up int) {
+		t.Helper()
+		server.PushTraffic(capi.Traffic{Up: up})
+		for {
+			select {
+			case value := <-fast.C:
+				if value.Up == up {
+					return
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("%d did not arrive", up)
+			}
+		}
+	}
+	push(100)
+	if value := <-slow.C; value.Up != 100 {
+		t.Fatalf("slow got %+v, want the latest", value)
+	}
+	dropped := slow.Dropped()
+
+	for i := 2; i <= 5; i++ {
+		push(i)
+	}
+	select {
+	case value := <-slow.C:
+		if value.Up != 5 {
+			t.Fatalf("the slow subscriber kept %+v, want the latest", value)
+		}
+	default:
+		t.Fatal("the slow subscriber got nothing")
+	}
+	if slow.Dropped()-dropped != 3 {
+		t.Fatalf("dropped %d values, want 3", slow.Dropped()-dropped)
+	}
+}
+
+func TestHubWaitAfterStreamGivesUp(t *testing.T) {
+	server, hub := newTestHub(t)
+	s := hub.Traffic.Subscribe(context.Background(), event.Options{Buffer: 8})
+	defer s.Close()
+	pushUntil(t, server, capi.Traffic{Up: 1}, s)
+
+	// the reconnect is answered with a status retrying can not fix
+	server.Fail("/traffic", 404, "Not Found")
+	server.DropStreams()
+	timeout := time.After(2 * time.Second)
+	for ended := false; !ended; {
+		select {
+		case _, ok := <-s.C:
+			ended = !ok
+		case <-timeout:
+			t.Fatal("the subscription did not end with the stream")
+		}
+	}
+	waitHub(t, hub)
+}
@@ -0,0 +1,27 @@
+package capi
+
+import (
+	"context"
+	"net/url"
+	"time"
+)
+
+type LogEntry struct {
+	Type    string `json:"type"`
+	Payload string `json:"payload"`
+	// Time is not sent by sing-box, it is filled with the time the entry was received.
+	Time time.Time `json:"time"`
+}
+
+func (c *Client) StreamLogs(parentCtx context.Context, level string, handler func(entry LogEntry, stop context.CancelFunc), options *StreamOptions) error {
+	var query url.Values
+	if level != "" {
+		query = url.Values{"level": []string{level}}
+	}
+	return runJSONStream(c, parentCtx, "/logs", query, options, func(entry LogEntry, stop context.CancelFunc) {
+		if entry.Time.IsZero() {
+			entry.Time = time.Now()
+		}
+		handler(entry, stop)
+	})
+}
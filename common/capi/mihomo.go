@@ -0,0 +1,16 @@
+package capi
+
+import (
+	"context"
+	"net/http"
+)
+
+// Restart makes mihomo restart itself with its current config
+func (c *Client) Restart(ctx context.Context) error {
+	return c.doNoContent(ctx, http.MethodPost, "/restart", nil, nil)
+}
+
+// Upgrade makes mihomo download and switch to its latest release
+func (c *Client) Upgrade(ctx context.Context) error {
+	return c.doNoContent(ctx, http.MethodPost, "/upgrade", nil, nil)
+}
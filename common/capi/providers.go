@@ -0,0 +1,58 @@
+package capi
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/woshikedayaa/boxtray/common/constant"
+	"net/http"
+	"path"
+	"time"
+)
+
+type SubscriptionInfo struct {
+	Upload   int64 `json:"Upload"`
+	Download int64 `json:"Download"`
+	Total    int64 `json:"Total"`
+	Expire   int64 `json:"Expire"`
+}
+
+type ProxyProvider struct {
+	Name             string            `json:"name"`
+	Type             string            `json:"type"`
+	VehicleType      string            `json:"vehicleType"`
+	Proxies          []*Proxy          `json:"proxies"`
+	UpdatedAt        time.Time         `json:"updatedAt"`
+	SubscriptionInfo *SubscriptionInfo `json:"subscriptionInfo"`
+}
+
+type ProxyProviders struct {
+	Providers map[string]*ProxyProvider `json:"providers"`
+}
+
+func (c *Client) GetProxyProviders(ctx context.Context) (*ProxyProviders, error) {
+	bs, err := c.doGet(ctx, "/providers/proxies", nil)
+	if err != nil {
+		return nil, err
+	}
+	p := &ProxyProviders{Providers: make(map[string]*ProxyProvider)}
+	err = json.Unmarshal(bs, p)
+	if err != nil {
+		return nil, err
+	}
+	for _, provider := range p.Providers {
+		for _, node := range provider.Proxies {
+			if node != nil {
+				node.Type = constant.NormalizeProxyType(node.Type)
+			}
+		}
+	}
+	return p, nil
+}
+
+func (c *Client) UpdateProxyProvider(ctx context.Context, name string) error {
+	return c.doPut(ctx, path.Join("providers", "proxies", name), nil, nil)
+}
+
+func (c *Client) HealthCheckProxyProvider(ctx context.Context, name string) error {
+	return c.doNoContent(ctx, http.MethodGet, path.Join("providers", "proxies", name, "healthcheck"), nil, nil)
+}
@@ -1,12 +1,12 @@
 package capi
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
 	orderedmap "github.com/wk8/go-ordered-map/v2"
-	"net/http"
+	"github.com/woshikedayaa/boxtray/common/constant"
 	"path"
-	"strings"
 	"time"
 )
 
@@ -18,6 +18,13 @@ type History struct {
 	Time  time.Time `json:"time"`
 	Delay uint16    `json:"delay"`
 }
+
+// ProxyExtra is the delay history of a single test url, sent by mihomo
+type ProxyExtra struct {
+	Alive   bool      `json:"alive"`
+	History []History `json:"history"`
+}
+
 type Proxy struct {
 	Type    string    `json:"type"`
 	Name    string    `json:"name"`
@@ -26,10 +33,28 @@ type Proxy struct {
 	//
 	Now string   `json:"now"`
 	All []string `json:"all"`
+	// mihomo added, Alive is nil for sing-box
+	Alive *bool                  `json:"alive,omitempty"`
+	Extra map[string]*ProxyExtra `json:"extra,omitempty"`
 }
 
-func (c *Client) GetProxies() (*Proxies, error) {
-	bs, err := c.doGet("/proxies", nil)
+// DelayFor returns the last delay tested against url, falling back to the last delay of any url.
+// A node mihomo marks as dead has no delay.
+func (p *Proxy) DelayFor(url string) uint16 {
+	if extra, ok := p.Extra[url]; ok && url != "" {
+		if !extra.Alive || len(extra.History) == 0 {
+			return 0
+		}
+		return extra.History[len(extra.History)-1].Delay
+	}
+	if p.Alive != nil && !*p.Alive || len(p.History) == 0 {
+		return 0
+	}
+	return p.History[len(p.History)-1].Delay
+}
+
+func (c *Client) GetProxies(ctx context.Context) (*Proxies, error) {
+	bs, err := c.doGet(ctx, "/proxies", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -38,21 +63,18 @@ func (c *Client) GetProxies() (*Proxies, error) {
 	if err != nil {
 		return nil, err
 	}
+	for pair := p.Proxies.Oldest(); pair != nil; pair = pair.Next() {
+		if pair.Value != nil {
+			pair.Value.Type = constant.NormalizeProxyType(pair.Value.Type)
+		}
+	}
 	return p, nil
 }
 
-func (c *Client) SwitchProxy(selector string, target string) error {
-	req, err := c.getRequest(http.MethodPut, c.newEndpoint(path.Join("proxies", selector), nil).String(), strings.NewReader(fmt.Sprintf("{\"name\":\"%s\"}", target)))
-	if err != nil {
-		return err
-	}
-	resp, err := c.httpClient.Do(req)
+func (c *Client) SwitchProxy(ctx context.Context, selector string, target string) error {
+	bs, err := json.Marshal(map[string]string{"name": target})
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusOK {
-		return nil
-	}
-	return fmt.Errorf("unexcepted status code : %d", resp.StatusCode)
+	return c.doPut(ctx, path.Join("proxies", selector), nil, bytes.NewReader(bs))
 }
@@ -0,0 +1,176 @@
+package capi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RecordKindHTTP   = "http"
+	RecordKindStream = "stream"
+	RecordKindFrame  = "frame"
+
+	redacted = "<redacted>"
+)
+
+// RecordEntry is a line of a recording, Kind tells which fields are set
+type RecordEntry struct {
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"`
+	Method   string    `json:"method,omitempty"`
+	Path     string    `json:"path"`
+	Query    string    `json:"query,omitempty"`
+	Status   int       `json:"status,omitempty"`
+	Request  string    `json:"request,omitempty"`
+	Response string    `json:"response,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+var (
+	// secretField matches the value of a "secret" or "token" json field
+	secretField = regexp.MustCompile(`("(?i:secret|token)"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+	// secretParam matches the token query parameter inside an url, as dial errors print it
+	secretParam = regexp.MustCompile(`([?&]token=)[^&\s"]*`)
+)
+
+// Recorder writes every exchange of a client as JSONL, the secret never reaches the output.
+// Headers are not recorded, the secret only leaks through the token query parameter and secret or token fields,
+// anything else is written untouched so a replay serves exactly what the core did.
+type Recorder struct {
+	access  sync.Mutex
+	encoder *json.Encoder
+	// base is the path of the endpoint, paths are recorded relative to it
+	base string
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{encoder: json.NewEncoder(w)}
+}
+
+func redact(s string) string {
+	if s == "" {
+		return s
+	}
+	s = secretField.ReplaceAllString(s, `$1"`+redacted+`"`)
+	return secretParam.ReplaceAllString(s, "${1}"+url.QueryEscape(redacted))
+}
+
+// redactQuery may modify query, pass a parsed copy
+func (r *Recorder) redactQuery(query url.Values) string {
+	for _, key := range []string{"token", "secret"} {
+		if query.Has(key) {
+			query.Set(key, redacted)
+		}
+	}
+	return query.Encode()
+}
+
+func (r *Recorder) relative(ph string) string {
+	if r.base != "" && r.base != "/" {
+		ph = strings.TrimPrefix(ph, r.base)
+	}
+	return path.Clean("/" + ph)
+}
+
+func (r *Recorder) write(entry RecordEntry) {
+	if r == nil {
+		return
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.Path = r.relative(entry.Path)
+	entry.Request = redact(entry.Request)
+	entry.Response = redact(entry.Response)
+	entry.Error = redact(entry.Error)
+	r.access.Lock()
+	defer r.access.Unlock()
+	_ = r.encoder.Encode(entry)
+}
+
+func (r *Recorder) stream(u *url.URL, status int, err error) {
+	if r == nil {
+		return
+	}
+	entry := RecordEntry{Kind: RecordKindStream, Path: u.Path, Query: r.redactQuery(u.Query()), Status: status}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	r.write(entry)
+}
+
+func (r *Recorder) frame(ph string, message []byte) {
+	if r == nil {
+		return
+	}
+	r.write(RecordEntry{Kind: RecordKindFrame, Path: ph, Response: string(message)})
+}
+
+type recordTransport struct {
+	next     http.RoundTripper
+	recorder *Recorder
+	// maxRequestSize and maxResponseSize bound what is read to record, like the client does
+	maxRequestSize  int64
+	maxResponseSize int64
+}
+
+func (t *recordTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	entry := RecordEntry{
+		Time:   time.Now(),
+		Kind:   RecordKindHTTP,
+		Method: request.Method,
+		Path:   request.URL.Path,
+		Query:  t.recorder.redactQuery(request.URL.Query()),
+	}
+	if request.GetBody != nil {
+		if body, err := request.GetBody(); err == nil {
+			bs, _ := io.ReadAll(io.LimitReader(body, t.maxRequestSize))
+			body.Close()
+			entry.Request = string(bs)
+		}
+	}
+	response, err := t.next.RoundTrip(request)
+	if err != nil {
+		entry.Error = err.Error()
+		t.recorder.write(entry)
+		return nil, err
+	}
+	// one byte more than the client accepts, so it still notices an oversized body
+	bs, err := io.ReadAll(io.LimitReader(response.Body, t.maxResponseSize+1))
+	response.Body.Close()
+	response.Body = io.NopCloser(bytes.NewReader(bs))
+	entry.Status = response.StatusCode
+	entry.Response = string(bs)
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	t.recorder.write(entry)
+	return response, err
+}
+
+func ReadRecording(r io.Reader) ([]RecordEntry, error) {
+	var entries []RecordEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), 64<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		entry := RecordEntry{}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,82 @@
+package capi_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/woshikedayaa/boxtray/common/capi"
+	"github.com/woshikedayaa/boxtray/common/capi/capitest"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// a short secret that also shows up in the data must not corrupt the recording
+func TestRecordReplayShortSecret(t *testing.T) {
+	const secret = "a"
+	server := capitest.NewServer(secret)
+	defer server.Close()
+	server.SetProxies(
+		&capi.Proxy{Name: "a", Type: "shadowsocks"},
+		&capi.Proxy{Name: "select", Type: "selector", All: []string{"a"}, Now: "a"},
+	)
+
+	buffer := &bytes.Buffer{}
+	client, err := capi.NewClient(server.URL, &capi.ClientConfig{
+		Timeout:  time.Second,
+		Secret:   secret,
+		Recorder: capi.NewRecorder(buffer),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetProxies(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SwitchProxy(context.Background(), "select", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := capi.ReadRecording(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("recorded %d entries, want 2", len(entries))
+	}
+
+	replay := httptest.NewServer(capi.NewReplayHandler(entries))
+	defer replay.Close()
+	replayClient, err := capi.NewClient(replay.URL, &capi.ClientConfig{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxies, err := replayClient.GetProxies(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proxy, ok := proxies.Proxies.Get("select"); !ok || proxy.Now != "a" || len(proxy.All) != 1 {
+		t.Fatalf("replayed select = %+v, want the recorded one", proxy)
+	}
+}
+
+func TestRecordRedactsToken(t *testing.T) {
+	const secret = "s3cr3t-token"
+	server := capitest.NewServer(secret)
+	defer server.Close()
+
+	buffer := &bytes.Buffer{}
+	client, err := capi.NewClient(server.URL+"/?token="+secret, &capi.ClientConfig{
+		Timeout:  time.Second,
+		Secret:   secret,
+		Recorder: capi.NewRecorder(buffer),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetVersion(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buffer.Bytes(), []byte(secret)) {
+		t.Fatalf("the secret leaked into the recording: %s", buffer.String())
+	}
+}
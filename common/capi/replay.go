@@ -0,0 +1,128 @@
+package capi
+
+import (
+	"github.com/gorilla/websocket"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// replayFrameGap bounds the wait between two replayed frames
+const replayFrameGap = 5 * time.Second
+
+type replayHandler struct {
+	access    sync.Mutex
+	responses map[string][]RecordEntry // method and path to the recorded answers
+	frames    map[string][]RecordEntry // path to the recorded frames
+	upgrader  websocket.Upgrader
+}
+
+// NewReplayHandler serves a recording as a controller, the answers of a method and path
+// are given in the recorded order and the last one is repeated, streams replay their frames.
+func NewReplayHandler(entries []RecordEntry) http.Handler {
+	h := &replayHandler{
+		responses: make(map[string][]RecordEntry),
+		frames:    make(map[string][]RecordEntry),
+	}
+	for _, entry := range entries {
+		switch entry.Kind {
+		case RecordKindHTTP:
+			key := entry.Method + " " + entry.Path
+			h.responses[key] = append(h.responses[key], entry)
+		case RecordKindFrame:
+			h.frames[entry.Path] = append(h.frames[entry.Path], entry)
+		}
+	}
+	return h
+}
+
+func (h *replayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if frames, ok := h.frames[r.URL.Path]; ok && r.Method == http.MethodGet {
+		h.serveStream(w, r, frames)
+		return
+	}
+	h.access.Lock()
+	key := r.Method + " " + r.URL.Path
+	queue := h.responses[key]
+	if len(queue) == 0 {
+		h.access.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"message":"not in recording"}`))
+		return
+	}
+	entry := queue[0]
+	if len(queue) > 1 {
+		h.responses[key] = queue[1:]
+	}
+	h.access.Unlock()
+
+	if entry.Status == 0 {
+		// the recorded request never got an answer, drop the connection like the dead core did
+		if hijacker, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hijacker.Hijack(); err == nil {
+				conn.Close()
+				return
+			}
+		}
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	if entry.Response != "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(entry.Status)
+	_, _ = w.Write([]byte(entry.Response))
+}
+
+func (h *replayHandler) serveStream(w http.ResponseWriter, r *http.Request, frames []RecordEntry) {
+	var send func(message []byte) error
+	done := r.Context().Done()
+	if websocket.IsWebSocketUpgrade(r) {
+		conn, err := h.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		closed := make(chan struct{})
+		done = closed
+		go func() {
+			// answer pings and notice the client leaving
+			defer close(closed)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+		send = func(message []byte) error {
+			return conn.WriteMessage(websocket.TextMessage, message)
+		}
+	} else {
+		flusher, _ := w.(http.Flusher)
+		w.Header().Set("Content-Type", "application/json")
+		send = func(message []byte) error {
+			if _, err := w.Write(append(message, '\n')); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return nil
+		}
+	}
+	for i, frame := range frames {
+		if i > 0 {
+			gap := min(max(frame.Time.Sub(frames[i-1].Time), 0), replayFrameGap)
+			select {
+			case <-done:
+				return
+			case <-time.After(gap):
+			}
+		}
+		if err := send([]byte(frame.Response)); err != nil {
+			return
+		}
+	}
+	<-done
+}
@@ -0,0 +1,178 @@
+package capi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open, controller considered down")
+
+type RetryPolicy struct {
+	// Attempts is the total number of tries, 1 disables retry
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// BreakerThreshold is the count of consecutive failures that opens the breaker, 0 disables it
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		Attempts:         3,
+		BaseDelay:        200 * time.Millisecond,
+		MaxDelay:         2 * time.Second,
+		BreakerThreshold: 5,
+		BreakerCooldown:  5 * time.Second,
+	}
+}
+
+// backoff is an exponential delay with jitter in [d/2, d)
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay << attempt
+	if d <= 0 || d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// delayTest reports the delay endpoints, /proxies/{name}/delay and /group/{name}/delay.
+// Their failures describe the tested nodes, both cores answer 503 for a dead node and 504 for a slow one.
+func delayTest(request *http.Request) bool {
+	segments := strings.Split(strings.Trim(request.URL.Path, "/"), "/")
+	n := len(segments)
+	return n >= 3 && segments[n-1] == "delay" && (segments[n-3] == "proxies" || segments[n-3] == "group")
+}
+
+// retryable tells whether a failed try is worth another one,
+// timeouts are not retried since they already took the whole client timeout.
+func retryable(response *http.Response, err error) bool {
+	if err != nil {
+		return !IsTimeout(err) && !errors.Is(err, context.Canceled)
+	}
+	return response.StatusCode == http.StatusBadGateway || response.StatusCode == http.StatusServiceUnavailable
+}
+
+type circuitBreaker struct {
+	access    sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (b *circuitBreaker) allow() error {
+	if b.threshold <= 0 {
+		return nil
+	}
+	b.access.Lock()
+	defer b.access.Unlock()
+	if b.failures < b.threshold {
+		return nil
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return ErrCircuitOpen
+	}
+	// half open, let a single request probe the controller
+	b.probing = true
+	return nil
+}
+
+func (b *circuitBreaker) release() {
+	if b.threshold <= 0 {
+		return
+	}
+	b.access.Lock()
+	defer b.access.Unlock()
+	b.probing = false
+}
+
+func (b *circuitBreaker) record(failed bool) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.access.Lock()
+	defer b.access.Unlock()
+	b.probing = false
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// do sends the request with the retry policy and the circuit breaker of the client
+func (c *Client) do(request *http.Request) (*http.Response, error) {
+	if err := c.breaker.allow(); err != nil {
+		return nil, fmt.Errorf("%s %s: %w", request.Method, request.URL.Path, err)
+	}
+	policy := c.config.Retry
+	attempts := 1
+	// a body that can not be sent again is not worth a retry
+	replayable := request.Body == nil || request.Body == http.NoBody || request.GetBody != nil
+	if idempotent(request.Method) && !delayTest(request) && replayable {
+		attempts = max(1, policy.Attempts)
+	}
+	var (
+		response *http.Response
+		err      error
+	)
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			if request.GetBody != nil {
+				body, bodyErr := request.GetBody()
+				if bodyErr != nil {
+					// the last response is closed already, and the controller is not to blame
+					c.breaker.release()
+					return nil, fmt.Errorf("%s %s: replay body: %w", request.Method, request.URL.Path, bodyErr)
+				}
+				request.Body = body
+			}
+			select {
+			case <-request.Context().Done():
+				return nil, request.Context().Err()
+			case <-time.After(policy.backoff(attempt - 1)):
+			}
+		}
+		response, err = c.httpClient.Do(request)
+		if !retryable(response, err) || attempt == attempts-1 {
+			break
+		}
+		if response != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, c.config.MaxResponseSize))
+			response.Body.Close()
+		}
+	}
+	failed := err != nil || retryable(response, nil)
+	if errors.Is(err, context.Canceled) || failed && delayTest(request) {
+		// says nothing about the controller
+		c.breaker.release()
+		return response, err
+	}
+	// 504 is a timeout of the core itself, only transport errors, 502 and 503 blame the controller
+	c.breaker.record(failed)
+	return response, err
+}
@@ -0,0 +1,48 @@
+package capi
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// a body without GetBody can not be sent twice, the first answer is the one returned
+func TestRetryBodyWithoutReplay(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+	client, err := NewClient(server.URL, &ClientConfig{
+		Timeout: time.Second,
+		Retry:   &RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request, err := client.getRequest(context.Background(), http.MethodPut, server.URL+"/proxies/select", io.NopCloser(strings.NewReader("payload")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := client.do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("requests = %d, want 1", n)
+	}
+	body, err := io.ReadAll(response.Body)
+	if err != nil || string(body) != "payload" || response.StatusCode != http.StatusBadGateway {
+		t.Fatalf("response = %d %q %v, want the first answer", response.StatusCode, body, err)
+	}
+}
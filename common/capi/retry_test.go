@@ -0,0 +1,91 @@
+package capi_test
+
+import (
+	"context"
+	"errors"
+	"github.com/woshikedayaa/boxtray/common/capi"
+	"github.com/woshikedayaa/boxtray/common/capi/capitest"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newRetryClient(t *testing.T, server *capitest.Server, policy *capi.RetryPolicy) *capi.Client {
+	t.Helper()
+	client, err := capi.NewClient(server.URL, &capi.ClientConfig{Timeout: 2 * time.Second, Retry: policy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestRetryTransientStatus(t *testing.T) {
+	server := capitest.NewServer("")
+	defer server.Close()
+	client := newRetryClient(t, server, &capi.RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+
+	server.Fail("/version", http.StatusBadGateway, "")
+	if _, err := client.GetVersion(context.Background()); err == nil {
+		t.Fatal("expected an error")
+	}
+	if n := server.Requests("/version"); n != 3 {
+		t.Fatalf("requests = %d, want 3", n)
+	}
+
+	server.Fail("/version", http.StatusNotFound, "")
+	if _, err := client.GetVersion(context.Background()); !capi.IsNotFound(err) {
+		t.Fatalf("err = %v, want not found", err)
+	}
+	if n := server.Requests("/version"); n != 4 {
+		t.Fatalf("requests = %d, want 4, a 404 is not retried", n)
+	}
+}
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	server := capitest.NewServer("")
+	defer server.Close()
+	client := newRetryClient(t, server, &capi.RetryPolicy{Attempts: 1, BreakerThreshold: 2, BreakerCooldown: 50 * time.Millisecond})
+
+	server.Fail("/version", http.StatusServiceUnavailable, "")
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetVersion(context.Background()); err == nil {
+			t.Fatal("expected an error")
+		}
+	}
+	if _, err := client.GetVersion(context.Background()); !errors.Is(err, capi.ErrCircuitOpen) {
+		t.Fatalf("err = %v, want ErrCircuitOpen", err)
+	}
+	if n := server.Requests("/version"); n != 2 {
+		t.Fatalf("requests = %d, want 2, an open breaker sends nothing", n)
+	}
+
+	server.ClearFailures()
+	time.Sleep(60 * time.Millisecond)
+	if _, err := client.GetVersion(context.Background()); err != nil {
+		t.Fatalf("half open probe failed: %v", err)
+	}
+}
+
+func TestDelayFailuresDoNotOpenBreaker(t *testing.T) {
+	server := capitest.NewServer("")
+	defer server.Close()
+	server.SetProxies(&capi.Proxy{Name: "dead", Type: "shadowsocks"}, &capi.Proxy{Name: "group", Type: "selector", All: []string{"dead"}})
+	client := newRetryClient(t, server, &capi.RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, BreakerThreshold: 2, BreakerCooldown: time.Minute})
+
+	// dead has no delay, the fake answers 503 like the real cores
+	server.Fail("/group/group/delay", http.StatusServiceUnavailable, "An error occurred in the delay test")
+	for i := 0; i < 5; i++ {
+		if _, err := client.GetDelay(context.Background(), "dead", "", 100); err == nil {
+			t.Fatal("expected an error")
+		}
+		if _, err := client.GetGroupDelay(context.Background(), "group", "", 100); err == nil {
+			t.Fatal("expected an error")
+		}
+	}
+	if n := server.Requests("/proxies/dead/delay"); n != 5 {
+		t.Fatalf("requests = %d, want 5, delay tests are not retried", n)
+	}
+	if _, err := client.GetVersion(context.Background()); err != nil {
+		t.Fatalf("dead nodes opened the breaker: %v", err)
+	}
+}
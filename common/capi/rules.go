@@ -0,0 +1,62 @@
+package capi
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"time"
+)
+
+type Rule struct {
+	Type    string `json:"type"`
+	Payload string `json:"payload"`
+	Proxy   string `json:"proxy"`
+}
+
+type Rules struct {
+	Rules []Rule `json:"rules"`
+}
+
+type RuleProvider struct {
+	Name        string    `json:"name"`
+	Type        string    `json:"type"`
+	Behavior    string    `json:"behavior"`
+	Format      string    `json:"format"`
+	RuleCount   int       `json:"ruleCount"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	VehicleType string    `json:"vehicleType"`
+}
+
+type RuleProviders struct {
+	Providers map[string]*RuleProvider `json:"providers"`
+}
+
+func (c *Client) GetRules(ctx context.Context) (*Rules, error) {
+	bs, err := c.doGet(ctx, "/rules", nil)
+	if err != nil {
+		return nil, err
+	}
+	r := &Rules{}
+	err = json.Unmarshal(bs, r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (c *Client) GetRuleProviders(ctx context.Context) (*RuleProviders, error) {
+	bs, err := c.doGet(ctx, "/providers/rules", nil)
+	if err != nil {
+		return nil, err
+	}
+	r := &RuleProviders{Providers: make(map[string]*RuleProvider)}
+	err = json.Unmarshal(bs, r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (c *Client) UpdateRuleProvider(ctx context.Context, name string) error {
+	return c.doPut(ctx, path.Join("providers", "rules", name), nil, nil)
+}
@@ -0,0 +1,129 @@
+package capi
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand/v2"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+type StreamState uint32
+
+const (
+	StreamConnecting StreamState = iota
+	StreamLive
+	StreamRetrying
+	StreamClosed
+)
+
+func (s StreamState) String() string {
+	switch s {
+	case StreamConnecting:
+		return "connecting"
+	case StreamLive:
+		return "live"
+	case StreamRetrying:
+		return "retrying"
+	case StreamClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+type StreamOptions struct {
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// OnState is called on every state change, err is the reason of a retry
+	OnState func(state StreamState, err error)
+}
+
+// Stream follows a streaming endpoint and reconnects with backoff until its context is done
+type Stream struct {
+	client  *Client
+	path    string
+	query   url.Values
+	options StreamOptions
+	state   atomic.Uint32
+}
+
+func (c *Client) NewStream(ph string, query url.Values, options *StreamOptions) *Stream {
+	s := &Stream{client: c, path: ph, query: query}
+	// a stream is closed until Run, so the first connecting is reported
+	s.state.Store(uint32(StreamClosed))
+	if options != nil {
+		s.options = *options
+	}
+	if s.options.MinBackoff <= 0 {
+		s.options.MinBackoff = 500 * time.Millisecond
+	}
+	if s.options.MaxBackoff < s.options.MinBackoff {
+		s.options.MaxBackoff = max(s.options.MinBackoff, 30*time.Second)
+	}
+	return s
+}
+
+func (s *Stream) State() StreamState {
+	return StreamState(s.state.Load())
+}
+
+func (s *Stream) setState(state StreamState, err error) {
+	if s.state.Swap(uint32(state)) == uint32(state) && err == nil {
+		return
+	}
+	if s.options.OnState != nil {
+		s.options.OnState(state, err)
+	}
+}
+
+// Run delivers every message to handler, it returns nil once ctx is done,
+// or the error of the handler or of a connect attempt that retrying can not fix.
+func (s *Stream) Run(ctx context.Context, handler func(message []byte) error) error {
+	defer s.setState(StreamClosed, nil)
+	backoff := s.options.MinBackoff
+	for {
+		s.setState(StreamConnecting, nil)
+		stream, errorC, err := s.client.doGetStream(ctx, s.path, s.query)
+		if err == nil {
+			s.setState(StreamLive, nil)
+			backoff = s.options.MinBackoff
+			for message := range stream {
+				if err := handler(message); err != nil {
+					return err
+				}
+			}
+			err = <-errorC
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if IsUnauthorized(err) || IsNotFound(err) {
+			return err
+		}
+		s.setState(StreamRetrying, err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff/2 + rand.N(backoff/2+1)):
+		}
+		backoff = min(backoff*2, s.options.MaxBackoff)
+	}
+}
+
+// runJSONStream decodes every message of the stream at ph into T
+func runJSONStream[T any](c *Client, parentCtx context.Context, ph string, query url.Values, options *StreamOptions, handler func(value T, stop context.CancelFunc)) error {
+	ctx, cancelFunc := context.WithCancel(parentCtx)
+	defer cancelFunc()
+	return c.NewStream(ph, query, options).Run(ctx, func(message []byte) error {
+		var value T
+		if err := json.Unmarshal(message, &value); err != nil {
+			return err
+		}
+		if ctx.Err() == nil {
+			handler(value, cancelFunc)
+		}
+		return nil
+	})
+}
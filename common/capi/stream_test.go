@@ -0,0 +1,103 @@
+package capi_test
+
+import (
+	"context"
+	"github.com/woshikedayaa/boxtray/common/capi"
+	"github.com/woshikedayaa/boxtray/common/capi/capitest"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStreamStates(t *testing.T) {
+	server := capitest.NewServer("")
+	defer server.Close()
+	client, err := capi.NewClient(server.URL, &capi.ClientConfig{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		access sync.Mutex
+		states []capi.StreamState
+	)
+	live := make(chan struct{}, 4)
+	options := &capi.StreamOptions{
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 10 * time.Millisecond,
+		OnState: func(state capi.StreamState, _ error) {
+			access.Lock()
+			states = append(states, state)
+			access.Unlock()
+			if state == capi.StreamLive {
+				live <- struct{}{}
+			}
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- client.GetTraffic(ctx, func(capi.Traffic, context.CancelFunc) {}, options)
+	}()
+
+	waitLive := func() {
+		select {
+		case <-live:
+		case <-time.After(2 * time.Second):
+			t.Fatal("stream did not come up")
+		}
+	}
+	waitLive()
+	server.DropStreams()
+	waitLive()
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	access.Lock()
+	defer access.Unlock()
+	want := []capi.StreamState{capi.StreamConnecting, capi.StreamLive, capi.StreamRetrying, capi.StreamConnecting, capi.StreamLive, capi.StreamClosed}
+	if !slices.Equal(states, want) {
+		t.Fatalf("states = %v, want %v", states, want)
+	}
+}
+
+// a controller that takes the connection but never answers must not keep the stream connecting
+func TestChunkedStreamHeaderTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	client, err := capi.NewClient(server.URL, &capi.ClientConfig{
+		Timeout:         100 * time.Millisecond,
+		StreamTransport: capi.StreamTransportHTTP,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retrying := make(chan error, 1)
+	options := &capi.StreamOptions{OnState: func(state capi.StreamState, err error) {
+		if state == capi.StreamRetrying {
+			select {
+			case retrying <- err:
+			default:
+			}
+		}
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.GetTraffic(ctx, func(capi.Traffic, context.CancelFunc) {}, options)
+	select {
+	case err := <-retrying:
+		if err == nil {
+			t.Fatal("retrying without the error of the attempt")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the stream is still waiting for the headers")
+	}
+}
@@ -0,0 +1,91 @@
+package capi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"github.com/woshikedayaa/boxtray/common"
+	"os"
+	"strings"
+)
+
+type TLSConfig struct {
+	// CAFile is a PEM bundle used instead of the system roots
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the name checked against the server certificate
+	ServerName string
+	// PinSHA256 is the hex SHA-256 fingerprint of the server certificate,
+	// when set only the fingerprint is checked and the chain is not verified
+	PinSHA256 string
+	Insecure  bool
+}
+
+func (t *TLSConfig) build() (*tls.Config, error) {
+	if t == nil {
+		return nil, nil
+	}
+	config := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.Insecure,
+	}
+	if t.CAFile != "" {
+		bs, err := readFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bs) {
+			return nil, fmt.Errorf("no certificate found in ca file %s", t.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	if t.CertFile != "" || t.KeyFile != "" {
+		if t.CertFile == "" || t.KeyFile == "" {
+			return nil, fmt.Errorf("client certificate requires both cert file and key file")
+		}
+		certPEM, err := readFile(t.CertFile)
+		if err != nil {
+			return nil, fmt.Errorf("read cert file: %w", err)
+		}
+		keyPEM, err := readFile(t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read key file: %w", err)
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if t.PinSHA256 != "" {
+		pin, err := hex.DecodeString(strings.ReplaceAll(t.PinSHA256, ":", ""))
+		if err != nil || len(pin) != sha256.Size {
+			return nil, fmt.Errorf("invalid sha256 pin: %s", t.PinSHA256)
+		}
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return fmt.Errorf("server sent no certificate")
+			}
+			sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+			if !bytes.Equal(sum[:], pin) {
+				return fmt.Errorf("server certificate does not match pin, got %s", hex.EncodeToString(sum[:]))
+			}
+			return nil
+		}
+	}
+	return config, nil
+}
+
+func readFile(name string) ([]byte, error) {
+	name, err := common.ExpandHomePath(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(name)
+}
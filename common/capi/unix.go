@@ -0,0 +1,57 @@
+package capi
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"runtime"
+)
+
+const unixScheme = "unix"
+
+// unixEndpoint maps unix:///path/to/api.sock to the socket path and the http endpoint used on top of it
+func unixEndpoint(endp *url.URL) (string, *url.URL, error) {
+	socketPath := endp.Path
+	if socketPath == "" {
+		socketPath = endp.Opaque
+	}
+	if socketPath == "" {
+		return "", nil, fmt.Errorf("empty unix socket path")
+	}
+	if err := checkSocket(socketPath); err != nil {
+		return "", nil, err
+	}
+	return socketPath, &url.URL{Scheme: "http", Host: "localhost", RawQuery: endp.RawQuery}, nil
+}
+
+// checkSocket refuses a socket other users are able to write to,
+// a missing socket is fine since the core may not be started yet, the dialer checks it again before every dial.
+func checkSocket(socketPath string) error {
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s is not a unix socket", socketPath)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o002 != 0 {
+		return fmt.Errorf("unix socket %s is writable by other users (mode %s)", socketPath, info.Mode().Perm())
+	}
+	return nil
+}
+
+func unixDialer(socketPath string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		// the socket is replaced whenever the core restarts
+		if err := checkSocket(socketPath); err != nil {
+			return nil, err
+		}
+		d := net.Dialer{}
+		return d.DialContext(ctx, "unix", socketPath)
+	}
+}
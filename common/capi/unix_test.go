@@ -0,0 +1,91 @@
+package capi_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/woshikedayaa/boxtray/common/capi"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveUnix answers /version on a socket at socketPath with the given mode
+func serveUnix(t *testing.T, socketPath string, mode os.FileMode) {
+	t.Helper()
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(socketPath, mode); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /version", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(capi.Version{Version: "sing-box 1.11.0"})
+	})
+	server := &http.Server{Handler: mux}
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(func() { _ = server.Close() })
+}
+
+func newUnixClient(t *testing.T, socketPath string) (*capi.Client, error) {
+	t.Helper()
+	return capi.NewClient("unix://"+socketPath, &capi.ClientConfig{
+		Timeout: time.Second,
+		Retry:   &capi.RetryPolicy{Attempts: 1},
+	})
+}
+
+func TestUnixSocket(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix socket permissions")
+	}
+	socketPath := filepath.Join(t.TempDir(), "api.sock")
+	serveUnix(t, socketPath, 0o600)
+	client, err := newUnixClient(t, socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err := client.GetVersion(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version.Version != "sing-box 1.11.0" {
+		t.Fatalf("version = %q", version.Version)
+	}
+}
+
+func TestUnixSocketWorldWritable(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix socket permissions")
+	}
+	socketPath := filepath.Join(t.TempDir(), "api.sock")
+	serveUnix(t, socketPath, 0o666)
+	if _, err := newUnixClient(t, socketPath); err == nil || !strings.Contains(err.Error(), "writable") {
+		t.Fatalf("err = %v, want a writable socket error", err)
+	}
+}
+
+func TestUnixSocketCreatedLater(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix socket permissions")
+	}
+	socketPath := filepath.Join(t.TempDir(), "api.sock")
+	// the core is not started yet
+	client, err := newUnixClient(t, socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetVersion(context.Background()); err == nil {
+		t.Fatal("expected an error without a socket")
+	}
+	serveUnix(t, socketPath, 0o666)
+	if _, err := client.GetVersion(context.Background()); err == nil || !strings.Contains(err.Error(), "writable") {
+		t.Fatalf("err = %v, want a writable socket error", err)
+	}
+}
@@ -1,6 +1,9 @@
 package capi
 
-import "encoding/json"
+import (
+	"context"
+	"encoding/json"
+)
 
 type Version struct {
 	Meta    bool   `json:"meta"`
@@ -8,8 +11,8 @@ type Version struct {
 	Version string `json:"version"`
 }
 
-func (c *Client) GetVersion() (Version, error) {
-	bs, err := c.doGet("/version", nil)
+func (c *Client) GetVersion(ctx context.Context) (Version, error) {
+	bs, err := c.doGet(ctx, "/version", nil)
 	if err != nil {
 		return Version{}, err
 	}
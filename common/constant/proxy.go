@@ -1,5 +1,7 @@
 package constant
 
+import "strings"
+
 const (
 	TypeTun          = "tun"
 	TypeRedirect     = "redirect"
@@ -23,11 +25,48 @@ const (
 	TypeVLESS        = "vless"
 	TypeTUIC         = "tuic"
 	TypeHysteria2    = "hysteria2"
+	// mihomo only
+	TypeSnell  = "snell"
+	TypeMieru  = "mieru"
+	TypeAnyTLS = "anytls"
+	TypePass   = "pass"
+)
+
+const (
+	TypeSelector    = "selector"
+	TypeURLTest     = "urltest"
+	TypeFallback    = "fallback"
+	TypeLoadBalance = "loadbalance"
+	TypeRelay       = "relay"
 )
 
+// NormalizeProxyType maps the type names of mihomo, like "Selector", "Socks5" or "Reject",
+// to the sing-box ones used everywhere else.
+func NormalizeProxyType(proxyType string) string {
+	proxyType = strings.ToLower(proxyType)
+	switch proxyType {
+	case "socks5":
+		return TypeSOCKS
+	case "reject", "rejectdrop":
+		return TypeBlock
+	default:
+		return proxyType
+	}
+}
+
+func IsGroup(proxyType string) bool {
+	switch proxyType {
+	case TypeSelector, TypeURLTest, TypeFallback, TypeLoadBalance, TypeRelay:
+		return true
+	default:
+		return false
+	}
+}
+
 const (
-	TypeSelector = "selector"
-	TypeURLTest  = "urltest"
+	VehicleHTTP       = "HTTP"
+	VehicleFile       = "File"
+	VehicleCompatible = "Compatible"
 )
 
 func ProxyDisplayName(proxyType string) string {
@@ -80,6 +119,20 @@ func ProxyDisplayName(proxyType string) string {
 		return "Selector"
 	case TypeURLTest:
 		return "URLTest"
+	case TypeFallback:
+		return "Fallback"
+	case TypeLoadBalance:
+		return "LoadBalance"
+	case TypeRelay:
+		return "Relay"
+	case TypeSnell:
+		return "Snell"
+	case TypeMieru:
+		return "Mieru"
+	case TypeAnyTLS:
+		return "AnyTLS"
+	case TypePass:
+		return "Pass"
 	default:
 		return "Unknown"
 	}
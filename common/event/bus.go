@@ -0,0 +1,299 @@
+// Package event is a typed publish/subscribe bus, publishing never blocks.
+package event
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+const defaultBuffer = 16
+
+// Policy decides what a full subscription queue does with a new value
+type Policy uint8
+
+const (
+	// DropOldest makes room for the new value, a subscriber always ends up with the latest one
+	DropOldest Policy = iota
+	// DropNewest keeps the queue as it is and discards the new value
+	DropNewest
+)
+
+type Options struct {
+	// Buffer is the queue size, <= 0 uses a small default
+	Buffer int
+	Policy Policy
+}
+
+// Bus owns the subscriptions of its topics, and ends all of them on Close.
+type Bus struct {
+	access sync.Mutex
+	closed bool
+	// active counts the subscriptions not yet closed by their consumer
+	active  int
+	drained chan struct{}
+	// subscriptions are ended by Close
+	subscriptions map[ender]struct{}
+	// sources counts the runs of the sources, idle is closed while there is none
+	sources int
+	idle    chan struct{}
+}
+
+type ender interface {
+	end()
+}
+
+func NewBus() *Bus {
+	idle := make(chan struct{})
+	close(idle)
+	return &Bus{
+		drained:       make(chan struct{}),
+		subscriptions: make(map[ender]struct{}),
+		idle:          idle,
+	}
+}
+
+// Close stops the delivery of every topic and closes the channel of every subscription.
+// Publishing and subscribing after Close do nothing.
+func (b *Bus) Close() {
+	b.access.Lock()
+	defer b.access.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for s := range b.subscriptions {
+		s.end()
+	}
+	clear(b.subscriptions)
+	b.checkDrained()
+}
+
+// Wait blocks until every subscription is closed by its consumer after Close, or ctx is done.
+func (b *Bus) Wait(ctx context.Context) error {
+	select {
+	case <-b.drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WaitSources blocks until the sources stopped by the last unsubscriptions have returned, or ctx is done.
+func (b *Bus) WaitSources(ctx context.Context) error {
+	b.access.Lock()
+	idle := b.idle
+	b.access.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// checkDrained must be called with access held
+func (b *Bus) checkDrained() {
+	if b.closed && b.active == 0 {
+		select {
+		case <-b.drained:
+		default:
+			close(b.drained)
+		}
+	}
+}
+
+// Source feeds a topic, emit drops the values once ctx is done.
+// Returning before ctx is done ends the subscriptions of the topic.
+type Source[T any] func(ctx context.Context, emit func(T)) error
+
+// Topic delivers values of T to its subscribers, in the order they are published.
+type Topic[T any] struct {
+	bus  *Bus
+	name string
+	// subscribers is guarded by bus.access
+	subscribers map[*Subscription[T]]struct{}
+
+	source Source[T]
+	// cancel stops the running source, it is guarded by bus.access
+	cancel context.CancelFunc
+}
+
+func NewTopic[T any](bus *Bus, name string) *Topic[T] {
+	return &Topic[T]{
+		bus:         bus,
+		name:        name,
+		subscribers: make(map[*Subscription[T]]struct{}),
+	}
+}
+
+// NewSourceTopic returns a topic fed by source, which runs from the first subscriber until the last one leaves.
+func NewSourceTopic[T any](bus *Bus, name string, source Source[T]) *Topic[T] {
+	t := NewTopic[T](bus, name)
+	t.source = source
+	return t
+}
+
+func (t *Topic[T]) Name() string {
+	return t.name
+}
+
+// Publish queues value for every subscriber, a full queue is handled by the policy of its subscriber
+func (t *Topic[T]) Publish(value T) {
+	t.bus.access.Lock()
+	defer t.bus.access.Unlock()
+	t.publishLocked(value)
+}
+
+// publishLocked must be called with bus.access held
+func (t *Topic[T]) publishLocked(value T) {
+	if t.bus.closed {
+		return
+	}
+	for s := range t.subscribers {
+		s.offer(value)
+	}
+}
+
+// Subscribe adds a subscriber, it leaves the topic when ctx is done or on Close.
+// On a closed bus the subscription comes with C already closed.
+func (t *Topic[T]) Subscribe(ctx context.Context, options Options) *Subscription[T] {
+	if options.Buffer <= 0 {
+		options.Buffer = defaultBuffer
+	}
+	c := make(chan T, options.Buffer)
+	s := &Subscription[T]{C: c, c: c, topic: t, policy: options.Policy}
+
+	t.bus.access.Lock()
+	defer t.bus.access.Unlock()
+	if t.bus.closed {
+		s.ended, s.done = true, true
+		close(c)
+		return s
+	}
+	t.subscribers[s] = struct{}{}
+	t.bus.subscriptions[s] = struct{}{}
+	t.bus.active++
+	s.stop = context.AfterFunc(ctx, s.leave)
+	if t.source != nil && t.cancel == nil {
+		t.start()
+	}
+	return s
+}
+
+// start must be called with bus.access held
+func (t *Topic[T]) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+	bus := t.bus
+	if bus.sources == 0 {
+		bus.idle = make(chan struct{})
+	}
+	bus.sources++
+	go func() {
+		_ = t.source(ctx, func(value T) {
+			bus.access.Lock()
+			defer bus.access.Unlock()
+			if ctx.Err() != nil {
+				// a stopped source must not reach the subscribers of the next one
+				return
+			}
+			t.publishLocked(value)
+		})
+		bus.access.Lock()
+		defer bus.access.Unlock()
+		if ctx.Err() == nil {
+			// the source gave up on its own, let the subscribers know
+			for s := range t.subscribers {
+				s.end()
+			}
+			t.cancel = nil
+		}
+		cancel()
+		bus.sources--
+		if bus.sources == 0 {
+			close(bus.idle)
+		}
+	}()
+}
+
+// Subscription receives the values of a Topic through C.
+// C is closed when ctx is done, the source gives up or the bus closes, then Close must still be called once the consumer is done,
+// Bus.Wait waits for it.
+type Subscription[T any] struct {
+	C       <-chan T
+	c       chan T
+	topic   *Topic[T]
+	policy  Policy
+	dropped atomic.Uint64
+	stop    func() bool
+
+	// ended and done are guarded by bus.access
+	ended bool
+	done  bool
+}
+
+// Dropped returns how many values the policy discarded
+func (s *Subscription[T]) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close leaves the topic and marks the consumer done, it is safe to call more than once
+func (s *Subscription[T]) Close() {
+	bus := s.topic.bus
+	bus.access.Lock()
+	defer bus.access.Unlock()
+	if s.done {
+		return
+	}
+	s.end()
+	s.done = true
+	bus.active--
+	bus.checkDrained()
+}
+
+// leave ends the subscription when its ctx is done, the consumer may still be handling a value
+func (s *Subscription[T]) leave() {
+	s.topic.bus.access.Lock()
+	defer s.topic.bus.access.Unlock()
+	s.end()
+}
+
+// end must be called with bus.access held
+func (s *Subscription[T]) end() {
+	if s.ended {
+		return
+	}
+	s.ended = true
+	t := s.topic
+	delete(t.subscribers, s)
+	delete(t.bus.subscriptions, s)
+	if s.stop != nil {
+		s.stop()
+	}
+	close(s.c)
+	if len(t.subscribers) == 0 && t.cancel != nil {
+		t.cancel()
+		t.cancel = nil
+	}
+}
+
+// offer must be called with bus.access held, it never blocks
+func (s *Subscription[T]) offer(value T) {
+	for {
+		select {
+		case s.c <- value:
+			return
+		default:
+		}
+		if s.policy == DropNewest {
+			s.dropped.Add(1)
+			return
+		}
+		select {
+		case <-s.c:
+			s.dropped.Add(1)
+		default:
+		}
+	}
+}
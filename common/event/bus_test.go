@@ -0,0 +1,196 @@
+package event_test
+
+import (
+	"context"
+	"github.com/woshikedayaa/boxtray/common/event"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func receive[T any](t *testing.T, s *event.Subscription[T]) []T {
+	t.Helper()
+	var values []T
+	for {
+		select {
+		case value, ok := <-s.C:
+			if !ok {
+				return values
+			}
+			values = append(values, value)
+		case <-time.After(time.Second):
+			t.Fatal("C was not closed")
+		}
+	}
+}
+
+func TestPublishOrder(t *testing.T) {
+	bus := event.NewBus()
+	topic := event.NewTopic[int](bus, "numbers")
+	first := topic.Subscribe(context.Background(), event.Options{Buffer: 100})
+	second := topic.Subscribe(context.Background(), event.Options{Buffer: 100, Policy: event.DropNewest})
+
+	var want []int
+	for i := range 100 {
+		topic.Publish(i)
+		want = append(want, i)
+	}
+	bus.Close()
+	for _, s := range []*event.Subscription[int]{first, second} {
+		if values := receive(t, s); !slices.Equal(values, want) {
+			t.Fatalf("values = %v, want %v", values, want)
+		}
+		if dropped := s.Dropped(); dropped != 0 {
+			t.Fatalf("dropped = %d, want 0", dropped)
+		}
+		s.Close()
+	}
+}
+
+func TestDropPolicies(t *testing.T) {
+	bus := event.NewBus()
+	topic := event.NewTopic[int](bus, "numbers")
+	oldest := topic.Subscribe(context.Background(), event.Options{Buffer: 2, Policy: event.DropOldest})
+	newest := topic.Subscribe(context.Background(), event.Options{Buffer: 2, Policy: event.DropNewest})
+
+	// nobody reads, like a consumer stuck on the main thread
+	for i := range 5 {
+		topic.Publish(i)
+	}
+	bus.Close()
+	if values := receive(t, oldest); !slices.Equal(values, []int{3, 4}) {
+		t.Fatalf("drop oldest kept %v, want [3 4]", values)
+	}
+	if values := receive(t, newest); !slices.Equal(values, []int{0, 1}) {
+		t.Fatalf("drop newest kept %v, want [0 1]", values)
+	}
+	for _, s := range []*event.Subscription[int]{oldest, newest} {
+		if dropped := s.Dropped(); dropped != 3 {
+			t.Fatalf("dropped = %d, want 3", dropped)
+		}
+		s.Close()
+	}
+}
+
+// a subscriber that leaves with its ctx is not done before it says so, Wait must not return under its handler
+func TestWaitForConsumerAfterCancel(t *testing.T) {
+	bus := event.NewBus()
+	topic := event.NewTopic[int](bus, "numbers")
+	ctx, cancel := context.WithCancel(context.Background())
+	s := topic.Subscribe(ctx, event.Options{})
+
+	handling, release := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer s.Close()
+		for range s.C {
+			close(handling)
+			<-release
+		}
+	}()
+	topic.Publish(1)
+	<-handling
+	cancel()
+	bus.Close()
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer waitCancel()
+	if err := bus.Wait(waitCtx); err == nil {
+		t.Fatal("Wait returned while the handler was still running")
+	}
+	close(release)
+	if err := bus.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// run with -race, publishing keeps going while the subscribers leave and the bus closes
+func TestCloseRacesDelivery(t *testing.T) {
+	bus := event.NewBus()
+	topic := event.NewTopic[int](bus, "numbers")
+
+	var publishers sync.WaitGroup
+	for range 4 {
+		publishers.Add(1)
+		go func() {
+			defer publishers.Done()
+			for i := range 1000 {
+				topic.Publish(i)
+			}
+		}()
+	}
+	var consumers sync.WaitGroup
+	for i := range 8 {
+		ctx, cancel := context.WithCancel(context.Background())
+		s := topic.Subscribe(ctx, event.Options{Buffer: 4})
+		consumers.Add(1)
+		go func() {
+			defer consumers.Done()
+			defer s.Close()
+			for value := range s.C {
+				if value%100 == i {
+					cancel()
+				}
+			}
+		}()
+		defer cancel()
+	}
+	bus.Close()
+	publishers.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := bus.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	consumers.Wait()
+
+	// nothing happens after Close
+	topic.Publish(0)
+	if s := topic.Subscribe(context.Background(), event.Options{}); len(receive(t, s)) != 0 {
+		t.Fatal("a subscription after Close received a value")
+	}
+}
+
+func TestSourceTopic(t *testing.T) {
+	bus := event.NewBus()
+	started := make(chan context.Context, 2)
+	topic := event.NewSourceTopic(bus, "ticks", func(ctx context.Context, emit func(int)) error {
+		started <- ctx
+		for i := 0; ctx.Err() == nil; i++ {
+			emit(i)
+			time.Sleep(time.Millisecond)
+		}
+		return ctx.Err()
+	})
+	if err := bus.WaitSources(context.Background()); err != nil {
+		t.Fatal("a bus without sources is idle")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := topic.Subscribe(ctx, event.Options{Buffer: 1})
+	second := topic.Subscribe(context.Background(), event.Options{Buffer: 1})
+	run := <-started
+	<-first.C
+	<-second.C
+
+	cancel()
+	receive(t, first)
+	first.Close()
+	if run.Err() != nil {
+		t.Fatal("the source stopped while a subscriber is left")
+	}
+	second.Close()
+	<-run.Done()
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), time.Second)
+	defer waitCancel()
+	if err := bus.WaitSources(waitCtx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-started:
+		t.Fatal("the source started again")
+	default:
+	}
+}
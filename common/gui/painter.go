@@ -2,6 +2,8 @@ package gui
 
 import (
 	"fmt"
+	"github.com/woshikedayaa/boxtray/common/capi"
+	"strings"
 )
 
 /*
@@ -78,3 +80,28 @@ func TrafficText(t int) string {
 		return fmt.Sprintf("%6.2f Gbps", float64(t)/(1000*1000))
 	}
 }
+
+func DNSText(records []capi.DNSRecord) string {
+	if len(records) == 0 {
+		return "no answer"
+	}
+	lines := make([]string, 0, len(records))
+	for _, r := range records {
+		lines = append(lines, fmt.Sprintf("%s\t%s\t%d\t%s", r.Name, capi.DNSTypeName(r.Type), r.TTL, r.Data))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ErrorText tells why the core can not be reached
+func ErrorText(err error) string {
+	switch {
+	case err == nil:
+		return "online"
+	case capi.IsUnauthorized(err):
+		return "unauthorized"
+	case capi.IsTimeout(err):
+		return "timeout"
+	default:
+		return "offline Now"
+	}
+}
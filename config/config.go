@@ -9,25 +9,58 @@ type ControlConfig struct {
 	Stop  []string `json:"stop"`
 
 	Update []string `json:"update"`
+	// ConfigPath is the core config file reloaded through the api, empty means the one the core was started with
+	ConfigPath string `json:"config_path"`
+	// StartOnLaunch runs Start when boxtray launches and the core is not running,
+	// StopOnQuit runs Stop when boxtray quits.
+	StartOnLaunch bool `json:"start_on_launch"`
+	StopOnQuit    bool `json:"stop_on_quit"`
+}
+
+type TLSConfig struct {
+	CAFile     string `json:"ca_file"`
+	CertFile   string `json:"cert_file"`
+	KeyFile    string `json:"key_file"`
+	ServerName string `json:"server_name"`
+	// PinSHA256 is the hex sha256 fingerprint of the server certificate
+	PinSHA256 string `json:"pin_sha256"`
+	Insecure  bool   `json:"insecure"`
+}
+
+// RetryConfig durations are in milliseconds, zero values keep the defaults
+type RetryConfig struct {
+	Attempts         int    `json:"attempts"`
+	BaseDelay        uint32 `json:"base_delay"`
+	MaxDelay         uint32 `json:"max_delay"`
+	BreakerThreshold int    `json:"breaker_threshold"`
+	BreakerCooldown  uint32 `json:"breaker_cooldown"`
 }
 
 type ApiConfig struct {
-	Scheme string `json:"scheme"`
-	Host   string `json:"host"`
-	Path   string `json:"path"`
-	Secret string `json:"secret"`
+	Scheme string      `json:"scheme"`
+	Host   string      `json:"host"`
+	Path   string      `json:"path"`
+	Secret string      `json:"secret"`
+	TLS    *TLSConfig  `json:"tls"`
+	Retry  RetryConfig `json:"retry"`
+	// StreamTransport is "auto", "websocket" or "http", auto falls back to http when the upgrade fails
+	StreamTransport string `json:"stream_transport"`
 
 	Control ControlConfig `json:"control"`
 }
 
 func (a ApiConfig) Endpoint() string {
-	if a.Scheme == "" || a.Scheme != "http" && a.Scheme != "https" {
+	if a.Scheme == "" || a.Scheme != "http" && a.Scheme != "https" && a.Scheme != "unix" {
 		a.Scheme = "http"
 	}
 	u := url.URL{}
 	u.Scheme = a.Scheme
 	u.Host = a.Host
 	u.Path = a.Path
+	if a.Scheme == "unix" {
+		// path is the socket path, e.g. unix:///run/sing-box/api.sock
+		u.Host = ""
+	}
 
 	return u.String()
 }
@@ -37,9 +70,33 @@ type LogConfig struct {
 	Disable bool   `json:"disable"`
 }
 
+const (
+	ProbeVersion = "version"
+	ProbeProxies = "proxies"
+	ProbeURLTest = "url_test"
+)
+
+// HealthConfig durations are in milliseconds, zero values keep the defaults
+type HealthConfig struct {
+	// Probe is "version", "proxies" or "url_test", url_test tests box.url_test through Proxy
+	Probe string `json:"probe"`
+	// Proxy is required by url_test, GLOBAL is not a good pick as sing-box may not test it
+	Proxy string `json:"proxy"`
+	// Interval is the delay between probes, it doubles up to DownInterval while the core is down
+	Interval     uint32 `json:"interval"`
+	DownInterval uint32 `json:"down_interval"`
+	Timeout      uint32 `json:"timeout"`
+	// FailureThreshold failed probes in a row take the core down, SuccessThreshold successful ones bring it back
+	FailureThreshold int `json:"failure_threshold"`
+	SuccessThreshold int `json:"success_threshold"`
+}
+
 type BoxConfig struct {
-	UrlTest  string `json:"url_test"`
-	MaxDelay uint16 `json:"max_delay"`
+	UrlTest  string       `json:"url_test"`
+	MaxDelay uint16       `json:"max_delay"`
+	Health   HealthConfig `json:"health"`
+	// ShutdownTimeout is how long quitting waits for the core to stop and the streams to end, in milliseconds
+	ShutdownTimeout uint32 `json:"shutdown_timeout"`
 }
 type Config struct {
 	Api ApiConfig `json:"api"`
@@ -32,3 +32,8 @@ func Init(config config.LogConfig) error {
 func Get(field string) *Logger {
 	return globalLogger.With(slog.String(FieldKey, field))
 }
+
+// Flush writes out what the handler has not written yet, call it before the process exits
+func Flush() {
+	_ = os.Stdout.Sync()
+}
@@ -15,11 +15,15 @@ import (
 var (
 	configFile string
 	version    bool
+	record     string
+	replay     string
 )
 
 func init() {
 	flag.StringVar(&configFile, "c", "~/.config/boxtray/config.json", "Set the config file")
 	flag.BoolVar(&version, "version", false, "display version")
+	flag.StringVar(&record, "record", "", "Record the controller session to a file")
+	flag.StringVar(&replay, "replay", "", "Serve a recorded session as the controller")
 	flag.Parse()
 }
 
@@ -52,5 +56,8 @@ func main() {
 		_, _ = fmt.Fprintln(os.Stderr, "level: ", err.Error())
 		os.Exit(1)
 	}
-	os.Exit(boxtray.Main(cfg))
+	os.Exit(boxtray.Main(cfg, boxtray.Options{
+		Record: record,
+		Replay: replay,
+	}))
 }